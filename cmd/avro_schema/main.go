@@ -0,0 +1,54 @@
+package main
+
+import (
+	"csvreader/pkg/constants"
+	"csvreader/pkg/utils"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Samples the users CSV and infers an Avro record schema from its header and values,
+// writing it to a .avsc file that csv_app can load with -avro-schema:
+//
+//	go run ./cmd/avro_schema -input users_million.csv -output resources/schemas/user.avsc
+func main() {
+	input := flag.String("input", constants.UsersFile, "CSV file to sample (also gzip, zstd or bzip2 compressed)")
+	output := flag.String("output", constants.AvroSchemaFileName, "where to write the inferred .avsc schema")
+	delimiter := flag.String("delimiter", string(constants.Separator), "field delimiter of the CSV")
+	sample := flag.Int("sample", constants.SchemaSampleRows, "number of rows sampled to infer the types (0 = whole file)")
+	name := flag.String("name", "User", "name of the Avro record")
+	namespace := flag.String("namespace", "", "namespace of the Avro record")
+	flag.Parse()
+
+	if err := run(*input, *output, *delimiter, *sample, *name, *namespace); err != nil {
+		fmt.Fprintln(os.Stderr, "avro_schema:", err)
+		os.Exit(1)
+	}
+}
+
+func run(input, output, delimiter string, sample int, name, namespace string) error {
+	separator := []rune(delimiter)
+	if len(separator) != 1 {
+		return fmt.Errorf("the delimiter must be a single character, got %q", delimiter)
+	}
+
+	file, _, err := utils.OpenInput(input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cfg := utils.DefaultCSVConfig()
+	cfg.Separator = separator[0]
+	schema, err := utils.InferAvroSchema(file, cfg, sample, name, namespace)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(output, []byte(schema+"\n"), 0644); err != nil {
+		return fmt.Errorf("error writing schema to %s: %v", output, err)
+	}
+	fmt.Printf("Avro schema inferred from %s written to %s:\n%s\n", input, output, schema)
+	return nil
+}
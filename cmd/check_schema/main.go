@@ -0,0 +1,50 @@
+package main
+
+import (
+	"csvreader/internal/schemaregistry"
+	"csvreader/pkg/constants"
+	"csvreader/pkg/utils"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Checks an Avro schema against the versions registered in the Schema Registry, so that CI can reject
+// a schema change that would break the existing consumers before it is deployed:
+//
+//	go run ./cmd/check_schema -schema resources/files/generated/user.avsc -compatibility FULL
+//
+// It exits with status 1 and prints the incompatible fields of each version if the check fails.
+func main() {
+	schemaFile := flag.String("schema", "", "Avro schema (.avsc) to check (default: built-in User schema)")
+	registry := flag.String("registry", constants.SchemaRegistryURL, "Schema Registry URL")
+	subject := flag.String("subject", schemaregistry.ValueSubject(constants.KafkaAvroTopic), "subject whose registered versions the schema is checked against")
+	compatibility := flag.String("compatibility", string(schemaregistry.CompatibilityBackward), "compatibility rule: BACKWARD, FORWARD or FULL against the latest version; add _TRANSITIVE to check every version")
+	flag.Parse()
+
+	if err := run(*schemaFile, *registry, *subject, *compatibility); err != nil {
+		fmt.Fprintln(os.Stderr, "check_schema:", err)
+		os.Exit(1)
+	}
+}
+
+func run(schemaFile, registry, subject, compatibility string) error {
+	level, err := schemaregistry.ParseCompatibility(compatibility)
+	if err != nil {
+		return err
+	}
+
+	schema := utils.UserAvroSchema
+	if schemaFile != "" {
+		if schema, err = utils.LoadAvroSchema(schemaFile); err != nil {
+			return err
+		}
+	}
+
+	client := schemaregistry.NewClient(registry)
+	if err := client.CheckCompatibility(subject, schemaregistry.Schema{Schema: schema}, level); err != nil {
+		return err
+	}
+	fmt.Printf("Schema is %s compatible with the versions registered under %s\n", level, subject)
+	return nil
+}
@@ -1,45 +1,459 @@
 package main
 
 import (
+	"csvreader/internal/checkpoint"
+	"csvreader/internal/producer/avro"
+	"csvreader/internal/producer/common"
 	"csvreader/internal/producer/json"
+	"csvreader/internal/producer/protobuf"
+	"csvreader/internal/schemaregistry"
 	"csvreader/internal/service"
 	"csvreader/pkg/constants"
 	"csvreader/pkg/logger"
 	"csvreader/pkg/utils"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// options contiene i flag della riga di comando
+type options struct {
+	input, format, delimiter, fixedColumns, columns string
+	tolerant                                        bool
+	quarantineFile, validate                        string
+	maxErrors, parseWorkers                         int
+	chunkSize                                       int64
+	ordered, async, transactional                   bool
+	retries                                         int
+	retryBackoff                                    time.Duration
+	deadLetterTopic, deadLetterFile                 string
+	producerConfig, profile, producerSet            string
+	avroSchemaFile, key, partitioner                string
+	avroCompression                                 string
+	avroBlockSize                                   int
+	headers                                         string
+	checkpointFile                                  string
+	resume                                          bool
+	provision                                       bool
+	partitions, replicationFactor                   int
+	topicConfig                                     string
+	maxRate, maxBytesRate                           float64
+	wireFormat, topic, avroTopic, protobufTopic     string
+	schemaRegistry                                  string
+	registerSchema                                  bool
+	compatibility                                   string
+
+	// file generati e dimensione dei batch: costanti nella run, diversi nei test
+	jsonFile, avroFile string
+	batchSize          int
+}
+
+func parseFlags() options {
+	opts := options{jsonFile: constants.JSONFileName, avroFile: constants.AvroFileName, batchSize: constants.BatchSize}
+	flag.StringVar(&opts.input, "input", constants.UsersFile, "users CSV file, glob (e.g. exports/users_*.csv) or directory of CSV parts")
+	flag.StringVar(&opts.format, "format", string(utils.FormatCSV), "input format: csv, tsv, jsonl or fixed")
+	flag.StringVar(&opts.delimiter, "delimiter", string(constants.Separator), "field delimiter of the csv format")
+	flag.StringVar(&opts.fixedColumns, "fixed-columns", constants.FixedWidthColumns, "layout of the fixed format as Field:start:width, e.g. ID:0:10,NomeUtente:10:30,Email:40:50")
+	flag.StringVar(&opts.columns, "columns", "", "header column mapping, e.g. user_name=NomeUtente,mail=Email")
+	flag.BoolVar(&opts.tolerant, "tolerant", false, "write malformed rows to the quarantine file and keep going instead of aborting")
+	flag.StringVar(&opts.quarantineFile, "quarantine", constants.QuarantineFileName, "quarantine file for the rows rejected in tolerant mode")
+	flag.StringVar(&opts.validate, "validate", "", `validation rules, e.g. "ID:positive,unique;NomeUtente:required,max=50;Email:required,email" or "default"; invalid rows go to the quarantine (implies -tolerant)`)
+	flag.IntVar(&opts.maxErrors, "max-errors", constants.MaxRejectedRows, "abort the tolerant run once more rows than this are rejected (0 = no limit)")
+	flag.IntVar(&opts.parseWorkers, "parse-workers", 1, "goroutines parsing the CSV in parallel byte-range chunks (1 = sequential); not for quoted fields spanning lines, -tolerant, -validate or the source-line header")
+	flag.Int64Var(&opts.chunkSize, "chunk-size", constants.ChunkSize, "size in bytes of the chunks parsed in parallel")
+	flag.BoolVar(&opts.ordered, "ordered", true, "keep the original row order when parsing in parallel")
+	flag.BoolVar(&opts.async, "async", false, "produce to Kafka without waiting for the delivery reports at every batch boundary")
+	flag.BoolVar(&opts.transactional, "transactional", false, "commit every batch as one Kafka transaction, aborted on any delivery error")
+	flag.IntVar(&opts.retries, "retries", common.DefaultRetryPolicy().MaxRetries, "retries with exponential backoff of a message whose delivery failed with a retriable error")
+	flag.DurationVar(&opts.retryBackoff, "retry-backoff", common.DefaultRetryPolicy().InitialBackoff, "wait before the first retry, doubled at every retry")
+	flag.StringVar(&opts.deadLetterTopic, "dead-letter-topic", "", "topic receiving the messages that exhaust their retries, with the error headers")
+	flag.StringVar(&opts.deadLetterFile, "dead-letter-file", "", "JSON Lines file receiving the messages that exhaust their retries, alternative to -dead-letter-topic")
+	flag.StringVar(&opts.producerConfig, "producer-config", "", "JSON file with the producer tuning, e.g. resources/config/producer.json")
+	flag.StringVar(&opts.profile, "profile", "", "librdkafka tuning profile: throughput, low-latency or durable (overrides the one of -producer-config)")
+	flag.StringVar(&opts.producerSet, "producer-set", "", "librdkafka properties overriding the profile, e.g. linger.ms=20,acks=all")
+	flag.StringVar(&opts.avroSchemaFile, "avro-schema", "", "Avro schema (.avsc) used for the Avro output, e.g. generated by cmd/avro_schema (default: built-in User schema)")
+	flag.StringVar(&opts.avroCompression, "avro-compression", utils.DefaultAvroOCFOptions().Compression, "compression of the blocks of the Avro container file: null, deflate or snappy")
+	flag.IntVar(&opts.avroBlockSize, "avro-block-size", utils.DefaultAvroOCFOptions().BlockSize, "records per block of the Avro container file")
+	flag.StringVar(&opts.key, "key", common.KeyNone, "message key for per-user ordering: none, id, email-hash or field:<User field>")
+	flag.StringVar(&opts.partitioner, "partitioner", "", "custom partitioner of the keyed messages: murmur2, fnv1a or id-modulo (default: librdkafka's)")
+	flag.StringVar(&opts.headers, "headers", common.HeaderSourceFile, "provenance headers added to every message: all, none or a list of source-file, source-line, schema-version, content-type, producer-hostname, run-started-at, checksum")
+	flag.StringVar(&opts.checkpointFile, "checkpoint", constants.CheckpointFileName, "file recording the last row whose Kafka delivery was confirmed")
+	flag.BoolVar(&opts.resume, "resume", false, "skip the rows already delivered according to the checkpoint file")
+	flag.BoolVar(&opts.provision, "provision", false, "create the topic if it does not exist, or verify that it matches -partitions, -replication-factor and -topic-config, before producing")
+	flag.IntVar(&opts.partitions, "partitions", constants.TopicPartitions, "partitions of the topic created or verified by -provision")
+	flag.IntVar(&opts.replicationFactor, "replication-factor", constants.TopicReplication, "replication factor of the topic created or verified by -provision")
+	flag.StringVar(&opts.topicConfig, "topic-config", "", "topic configs created or verified by -provision, e.g. retention.ms=604800000,cleanup.policy=delete")
+	flag.Float64Var(&opts.maxRate, "max-rate", 0, "maximum messages produced per second, e.g. to stay within the quotas of a shared cluster (0 = no limit)")
+	flag.Float64Var(&opts.maxBytesRate, "max-bytes-rate", 0, "maximum payload bytes produced per second (0 = no limit)")
+	flag.StringVar(&opts.wireFormat, "wire-format", wireJSON, "format of the Kafka messages: json, avro, both (JSON to -topic and Avro to -avro-topic) or protobuf")
+	flag.StringVar(&opts.topic, "topic", constants.KafkaTopic, "topic of the JSON messages")
+	flag.StringVar(&opts.avroTopic, "avro-topic", constants.KafkaAvroTopic, "topic of the Avro messages")
+	flag.StringVar(&opts.protobufTopic, "protobuf-topic", constants.KafkaProtobufTopic, "topic of the Protobuf messages")
+	flag.StringVar(&opts.schemaRegistry, "schema-registry", "", "Schema Registry URL, e.g. "+constants.SchemaRegistryURL+": Avro messages are framed with the schema ID (default: bare Avro binary)")
+	flag.BoolVar(&opts.registerSchema, "register-schema", true, "register the Avro schema under <avro-topic>-value if it is not registered yet")
+	flag.StringVar(&opts.compatibility, "compatibility", string(schemaregistry.CompatibilityBackward), "compatibility required between the Avro schema and the latest version registered under <avro-topic>-value: NONE, BACKWARD, FORWARD or FULL; add _TRANSITIVE to check every version")
+	flag.Parse()
+	return opts
+}
+
 // Design Pattern: FANOUT -<
 // The advantage of the Fan-Out pattern is that tasks are executed in parallel by the workers
 func main() {
+	opts := parseFlags()
+
+	if err := logger.Init(constants.LOGFILE); err != nil {
+		log.Fatal(err)
+	}
+
 	start := time.Now()
 
 	// Generate a correlation ID
 	correlationID := uuid.New().String()
 
-	// Get users from the service (they are read from a CSV file and converted into a list of Users)
-	users, err := service.GetUsers()
+	defer func() {
+		logger.InfoAsync("Whole run took ", time.Since(start))
+		logger.Close()
+	}()
+
+	// Configure the Kafka producer instance
+	sink, closeSink, err := newSink(opts, correlationID, start)
 	if err != nil {
+		logger.ErrorAsync("Failed to create kafkaProducerInstance: ", err)
+		return
+	}
+	defer closeSink()
+
+	if err := run(opts, sink, correlationID); err != nil {
 		logger.ErrorAsync(err)
 	}
+}
 
-	elapsed := time.Since(start)
-	defer logger.InfoAsync("Reading 1,000,000 users from CSV took ", elapsed)
+// Formati dei messaggi Kafka selezionabili con -wire-format
+const (
+	wireJSON     = "json"
+	wireAvro     = "avro"
+	wireBoth     = "both" // JSON e Avro su topic separati
+	wireProtobuf = "protobuf"
+)
 
-	// Configure the Kafka producer instance
-	kafkaProducerInstance, err := producer.NewProducer(constants.KafkaBootstrapServers, constants.KafkaTopic)
+// kafkaProducer è la parte comune dei producer JSON e Avro usata da main
+type kafkaProducer interface {
+	common.Sink
+	SetRecovery(recovery *common.Recovery)
+	SetHeaders(headers *common.Headers)
+	SetRateLimiter(limiter *common.RateLimiter)
+	SetKeying(keying *common.Keying) error
+	PartitionStats() []common.PartitionStats
+}
+
+// newSink creates the Kafka producers of the wire format configured by the flags and returns them as one sink,
+// with the function that closes them and the dead letter, logging the final stats of every topic
+func newSink(opts options, correlationID string, runStart time.Time) (common.Sink, func(), error) {
+	// In async mode the delivery reports are drained in the background and checked once at the end,
+	// in transactional mode every batch is a transaction: the two cannot be combined
+	if opts.async && opts.transactional {
+		return nil, nil, fmt.Errorf("-async and -transactional cannot be used together")
+	}
+	var formats []string
+	switch opts.wireFormat {
+	case wireJSON, wireAvro, wireProtobuf:
+		formats = []string{opts.wireFormat}
+	case wireBoth:
+		formats = []string{wireJSON, wireAvro}
+	default:
+		return nil, nil, fmt.Errorf("invalid wire format %q: expected json, avro, both or protobuf", opts.wireFormat)
+	}
+	if opts.async && opts.wireFormat != wireJSON {
+		return nil, nil, fmt.Errorf("-async is only supported with -wire-format json")
+	}
+	tuning, err := loadTuning(opts.producerConfig, opts.profile, opts.producerSet)
 	if err != nil {
-		logger.ErrorAsync("Failed to create kafkaProducerInstance:", err)
+		return nil, nil, fmt.Errorf("invalid producer config: %w", err)
+	}
+
+	// Every message carries the correlation ID and the provenance headers chosen for the run
+	headers, err := common.ParseHeaders(opts.headers, runStart)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid headers: %w", err)
+	}
+
+	// Failed deliveries are retried, then stored in the dead letter so that the run goes on
+	recovery, err := newRecovery(opts.retries, opts.retryBackoff, opts.deadLetterTopic, opts.deadLetterFile, tuning)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid dead letter: %w", err)
+	}
+
+	var producers []kafkaProducer
+	closeProducers := func() {
+		for _, p := range producers {
+			if err := p.Close(); err != nil {
+				logger.ErrorAsync("Error closing producer: ", err)
+			}
+		}
+		if recovery.DeadLetter != nil {
+			if err := recovery.DeadLetter.Close(); err != nil {
+				logger.ErrorAsync("Error closing dead letter: ", err)
+			}
+		}
+	}
+
+	for _, format := range formats {
+		topic := formatTopic(opts, format)
+		p, err := newProducer(opts, format, topic, tuning, correlationID)
+		if err != nil {
+			closeProducers()
+			return nil, nil, err
+		}
+		producers = append(producers, p)
+
+		// Messages of the same user share the key, so they land in the same partition and keep their order
+		keying, err := common.NewKeying(opts.key, opts.partitioner)
+		if err != nil {
+			closeProducers()
+			return nil, nil, fmt.Errorf("invalid message key: %w", err)
+		}
+
+		// Every producer has its own retry counters, key partitions and rate limit, the dead letter is shared.
+		// With a custom partitioner the retries go back to the partition it chose
+		p.SetRecovery(&common.Recovery{Policy: recovery.Policy, DeadLetter: recovery.DeadLetter, KeepPartition: keying.Partitioned()})
+		p.SetHeaders(headers)
+		p.SetRateLimiter(common.NewRateLimiter(opts.maxRate, opts.maxBytesRate))
+		if err := p.SetKeying(keying); err != nil {
+			closeProducers()
+			return nil, nil, fmt.Errorf("failed to configure message keys: %w", err)
+		}
+	}
+
+	closeSink := func() {
+		closeProducers()
+		for i, p := range producers {
+			logger.InfoAsync("Kafka stats of ", formatTopic(opts, formats[i]), ": ", p.Stats())
+			for _, partition := range p.PartitionStats() {
+				logger.InfoAsync("Partition stats: ", partition)
+			}
+		}
+	}
+	if len(producers) == 1 {
+		return producers[0], closeSink, nil
+	}
+	sinks := make([]common.Sink, len(producers))
+	for i, p := range producers {
+		sinks[i] = p
+	}
+	return common.NewMultiSink(sinks...), closeSink, nil
+}
+
+// formatTopic restituisce il topic dei messaggi nel formato 'format'
+func formatTopic(opts options, format string) string {
+	switch format {
+	case wireAvro:
+		return opts.avroTopic
+	case wireProtobuf:
+		return opts.protobufTopic
+	}
+	return opts.topic
+}
+
+// newProducer creates the producer of the messages in 'format' sent to 'topic', in the mode selected by the flags,
+// provisioning the topic first if requested so that a misconfigured topic stops the run early
+func newProducer(opts options, format, topic string, tuning *common.Tuning, correlationID string) (kafkaProducer, error) {
+	if opts.provision {
+		if err := provisionTopic(opts, topic); err != nil {
+			return nil, err
+		}
+	}
+
+	// One transactional.id per run and per producer
+	transactionalID := "csvreader-" + correlationID
+	if format == wireAvro {
+		transactionalID = "csvreader-avro-" + correlationID
+
+		avroSchema, err := loadAvroSchema(opts.avroSchemaFile)
+		if err != nil {
+			return nil, err
+		}
+		// The fields of an inferred schema are named after the renamed header columns
+		mapping, err := utils.ParseColumnMapping(opts.columns)
+		if err != nil {
+			return nil, fmt.Errorf("invalid column mapping: %w", err)
+		}
+		var p *avro.Producer
+		if opts.transactional {
+			p, err = avro.NewTransactionalProducerAvro(constants.KafkaBootstrapServers, topic, avroSchema, mapping, tuning, transactionalID)
+		} else {
+			p, err = avro.NewProducerAvro(constants.KafkaBootstrapServers, topic, avroSchema, mapping, tuning)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// With a registry the payloads are framed with the schema ID, otherwise they are bare Avro binary
+		if opts.schemaRegistry != "" {
+			client := schemaregistry.NewClient(opts.schemaRegistry)
+			subject := schemaregistry.ValueSubject(topic)
+
+			// A schema that would break the consumers of the registered versions stops the run before anything is sent
+			level, err := schemaregistry.ParseCompatibility(opts.compatibility)
+			if err == nil {
+				err = p.CheckCompatibility(client, subject, level)
+			}
+			if err != nil {
+				p.Close()
+				return nil, fmt.Errorf("incompatible Avro schema: %w", err)
+			}
+			if err := p.SetSchemaRegistry(client, subject, opts.registerSchema); err != nil {
+				p.Close()
+				return nil, fmt.Errorf("failed to resolve the Avro schema ID: %w", err)
+			}
+		}
+		return p, nil
+	}
+
+	if format == wireProtobuf {
+		return newProtobufProducer(opts, topic, tuning, "csvreader-protobuf-"+correlationID)
+	}
+
+	switch {
+	case opts.async:
+		return producer.NewAsyncProducer(constants.KafkaBootstrapServers, topic, tuning)
+	case opts.transactional:
+		return producer.NewTransactionalProducer(constants.KafkaBootstrapServers, topic, tuning, transactionalID)
+	default:
+		return producer.NewProducer(constants.KafkaBootstrapServers, topic, tuning)
+	}
+}
+
+// checkParseWorkers rifiuta le opzioni che il parsing parallelo non supporta: i chunk non conoscono
+// il numero di riga assoluto, richiesto dalla quarantena e dall'header source-line
+func checkParseWorkers(opts options) error {
+	if opts.parseWorkers <= 1 {
+		return nil
+	}
+	if opts.tolerant || opts.validate != "" {
+		return fmt.Errorf("-parse-workers > 1 cannot be combined with -tolerant or -validate: the quarantine needs the line of every rejected row")
+	}
+	// Un elenco di header non valido viene già segnalato alla creazione del sink
+	if headers, err := common.ParseHeaders(opts.headers, time.Time{}); err == nil && headers.Has(common.HeaderSourceLine) {
+		return fmt.Errorf("-parse-workers > 1 cannot be combined with the %s header: the line of the rows is not known", common.HeaderSourceLine)
+	}
+	return nil
+}
+
+// checkpointer è implementato dai sink che confermano sul checkpoint le righe consegnate
+type checkpointer interface {
+	SetCheckpoint(cp *checkpoint.Checkpoint)
+}
+
+// run reads the users configured by 'opts' and fans them out to the JSON file, the Avro file and 'sink'.
+// It returns an error if the configuration is invalid or if the reading or the production failed.
+func run(opts options, sink common.Sink, correlationID string) error {
+	if err := checkParseWorkers(opts); err != nil {
+		return err
 	}
-	defer kafkaProducerInstance.Close()
+
+	// Map the CSV header to the User fields: a missing required column stops the run before anything is sent
+	var err error
+	csvConfig := utils.DefaultCSVConfig()
+	csvConfig.Workers, csvConfig.ChunkSize, csvConfig.Ordered = opts.parseWorkers, opts.chunkSize, opts.ordered
+	if csvConfig.Format, err = utils.ParseInputFormat(opts.format); err != nil {
+		return fmt.Errorf("invalid input format: %w", err)
+	}
+	if csvConfig.Separator, err = parseDelimiter(opts.delimiter); err != nil {
+		return fmt.Errorf("invalid delimiter: %w", err)
+	}
+	if csvConfig.Format == utils.FormatFixedWidth {
+		if csvConfig.FixedWidth, err = utils.ParseFixedWidthColumns(opts.fixedColumns); err != nil {
+			return fmt.Errorf("invalid fixed-width layout: %w", err)
+		}
+	}
+	csvConfig.Mapping, err = utils.ParseColumnMapping(opts.columns)
+	if err != nil {
+		return fmt.Errorf("invalid column mapping: %w", err)
+	}
+
+	// Invalid users are routed to the quarantine like malformed rows, never to Kafka
+	tolerant := opts.tolerant
+	if opts.validate != "" {
+		rules, err := utils.ParseValidationRules(opts.validate)
+		if err != nil {
+			return fmt.Errorf("invalid validation rules: %w", err)
+		}
+		validator := utils.NewValidator(rules)
+		defer func() { logger.InfoAsync("Validation violations: ", validator.Summary()) }()
+		csvConfig.Validator = validator
+		tolerant = true
+	}
+
+	// In tolerant mode malformed rows go to the quarantine file instead of stopping the run
+	if tolerant {
+		quarantine, err := utils.NewQuarantine(opts.quarantineFile, opts.maxErrors)
+		if err != nil {
+			return fmt.Errorf("failed to create quarantine: %w", err)
+		}
+		defer func() {
+			if err := quarantine.Close(); err != nil {
+				logger.ErrorAsync("Error closing quarantine: ", err)
+			}
+			logger.InfoAsync("Rejected rows: ", quarantine.Rejected(), " (see ", quarantine.Filename(), ")")
+		}()
+		csvConfig.Quarantine = quarantine
+	}
+
+	// The Avro schema is loaded before reading anything, so a bad .avsc stops the run right away
+	avroSchema, err := loadAvroSchema(opts.avroSchemaFile)
+	if err != nil {
+		return err
+	}
+	avroOCF := utils.AvroOCFOptions{Compression: opts.avroCompression, BlockSize: opts.avroBlockSize}
+	if err := avroOCF.Validate(); err != nil {
+		return fmt.Errorf("invalid Avro file options: %w", err)
+	}
+
+	// Rows are numbered on the whole stream: checkpoints are only meaningful if the order is stable
+	var cp *checkpoint.Checkpoint
+	var resumeFrom int64
+	if opts.parseWorkers <= 1 || opts.ordered {
+		if opts.resume {
+			resumeFrom, err = checkpoint.Load(opts.checkpointFile, opts.input)
+			if err != nil {
+				return fmt.Errorf("failed to load checkpoint: %w", err)
+			}
+			logger.InfoAsync("Resuming after row ", resumeFrom)
+		}
+		cp = checkpoint.New(opts.checkpointFile, opts.input, resumeFrom)
+		if s, ok := sink.(checkpointer); ok {
+			s.SetCheckpoint(cp)
+		}
+	} else if opts.resume {
+		return fmt.Errorf("cannot resume with -ordered=false: the row order is not stable across runs")
+	} else {
+		logger.WarningAsync("Checkpoints are disabled with -ordered=false")
+	}
+
+	// Stream users from the service: they are read from the CSV file row by row,
+	// so Kafka production starts while the file is still being read
+	usersStream, readErrs, report, err := service.StreamUsers(opts.input, csvConfig)
+	if err != nil {
+		return fmt.Errorf("invalid input: %w", err)
+	}
+
+	// Every task receives its own copy of the stream (one per task below)
+	streams := utils.Tee(usersStream, 3)
 
 	// Create a WaitGroup to wait for all the workers to finish
 	var wg sync.WaitGroup
 
-	// Add the number of workers to the WaitGroup
+	// Add the number of workers to the WaitGroup.
+	// The three tasks consume the same stream, so they must run on different workers at the same time:
+	// NumWorkers must not be lower than the number of tasks
+	if constants.NumWorkers < len(streams) {
+		return fmt.Errorf("NumWorkers must be at least %d to consume the users stream", len(streams))
+	}
 	wg.Add(constants.NumWorkers)
 
 	// Main channel to send tasks
@@ -53,6 +467,9 @@ func main() {
 		go utils.Worker(channels[i], &wg)
 	}
 
+	// Errors of the Kafka task, returned at the end of the run
+	var kafkaErr error
+
 	// Send tasks to the main channel
 	go func() {
 		// Close the main channel when the function ends
@@ -60,17 +477,12 @@ func main() {
 
 		// First task: Write users to JSON file
 		mainCh <- func() {
-			utils.WriteUsersToJSONFile(users, constants.JSONFileName)
+			utils.WriteUsersStreamToJSONFile(streams[0], opts.jsonFile)
 		}
 
-		// Second task: Convert users to Avro and write to file
+		// Second task: Convert users to Avro and write them to an Avro container file
 		mainCh <- func() {
-			avroUsers, err := utils.ConvertUsersToAvro(users)
-			if err != nil {
-				logger.ErrorAsync("Error converting users to Avro:", err)
-				return
-			}
-			err = utils.WriteAvroToFile(avroUsers, constants.AvroFileName)
+			err := utils.WriteAvroStreamToFile(streams[1], opts.avroFile, avroSchema, csvConfig.Mapping, avroOCF)
 			if err != nil {
 				logger.ErrorAsync("Error writing Avro file:", err)
 				return
@@ -79,18 +491,39 @@ func main() {
 
 		// Third task: Send users to Kafka in batches
 		mainCh <- func() {
-			batches := utils.BatchUsers(users, constants.BatchSize)
+			// Batches are filled while the CSV is still being read
+			// When resuming, the rows already delivered are skipped (the files above are always rewritten in full)
+			batches := utils.BatchUsersStream(utils.SkipUsers(streams[2], resumeFrom), opts.batchSize)
 
 			// Start time for sending batches to Kafka
 			startBatchSend := time.Now()
 
-			for _, batch := range batches {
-				err := kafkaProducerInstance.ProduceBatch(batch, correlationID)
+			// A failed batch does not stop the run: the next batches are still produced and the failures
+			// are reported at the end. Its rows are never confirmed, so the checkpoint stays before them
+			var failedBatches, failedUsers int
+			var firstErr error
+			for batch := range batches {
+				err := sink.ProduceBatch(batch, correlationID)
+				saveCheckpoint(cp)
 				if err != nil {
-					logger.ErrorAsync("Error sending batch to Kafka:", err)
-					return
+					logger.ErrorAsync("Error sending batch to Kafka: ", err)
+					failedBatches++
+					failedUsers += len(batch)
+					if firstErr == nil {
+						firstErr = err
+					}
 				}
 			}
+			if failedBatches > 0 {
+				kafkaErr = fmt.Errorf("failed to send %d batches (%d users) to Kafka, the first with: %w", failedBatches, failedUsers, firstErr)
+			}
+
+			// Wait for the outstanding deliveries (async mode only) and report the failed ones
+			if err := sink.Flush(); err != nil {
+				logger.ErrorAsync("Error sending batches to Kafka: ", err)
+				kafkaErr = errors.Join(kafkaErr, fmt.Errorf("error sending batches to Kafka: %w", err))
+			}
+			saveCheckpoint(cp)
 
 			// Calculate elapsed time for sending batches to Kafka
 			elapsedBatchSend := time.Since(startBatchSend)
@@ -100,4 +533,134 @@ func main() {
 
 	// Wait for all the workers to finish
 	wg.Wait()
+
+	// The stream is over: report every file and check whether the reading ended with errors
+	report.Log()
+	readErr := <-readErrs
+	if readErr != nil {
+		readErr = fmt.Errorf("error reading users from CSV: %w", readErr)
+	}
+	return errors.Join(kafkaErr, readErr)
+}
+
+// parseDelimiter converts the -delimiter flag into the CSV separator; `\t` is accepted for tab
+func parseDelimiter(delimiter string) (rune, error) {
+	if delimiter == `\t` {
+		return '\t', nil
+	}
+	runes := []rune(delimiter)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("the delimiter must be a single character, got %q", delimiter)
+	}
+	return runes[0], nil
+}
+
+// loadTuning builds the producer tuning from the -producer-config file, if any,
+// then applies the -profile and -producer-set flags on top of it
+func loadTuning(configFile, profile, set string) (*common.Tuning, error) {
+	tuning := &common.Tuning{}
+	if configFile != "" {
+		var err error
+		if tuning, err = common.LoadTuning(configFile); err != nil {
+			return nil, err
+		}
+	}
+	if profile != "" {
+		tuning.Profile = profile
+	}
+
+	overrides, err := common.ParseOverrides(set)
+	if err != nil {
+		return nil, err
+	}
+	if tuning.Overrides == nil {
+		tuning.Overrides = make(map[string]string)
+	}
+	for key, value := range overrides {
+		tuning.Overrides[key] = value
+	}
+	return tuning, nil
+}
+
+// newProtobufProducer crea il producer Protobuf, con il wire format Confluent se è configurata la Schema Registry
+func newProtobufProducer(opts options, topic string, tuning *common.Tuning, transactionalID string) (kafkaProducer, error) {
+	var p *protobuf.Producer
+	var err error
+	if opts.transactional {
+		p, err = protobuf.NewTransactionalProducerProtobuf(constants.KafkaBootstrapServers, topic, tuning, transactionalID)
+	} else {
+		p, err = protobuf.NewProducerProtobuf(constants.KafkaBootstrapServers, topic, tuning)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.schemaRegistry != "" {
+		client := schemaregistry.NewClient(opts.schemaRegistry)
+		if err := p.SetSchemaRegistry(client, schemaregistry.ValueSubject(topic), opts.registerSchema); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("failed to resolve the Protobuf schema ID: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// provisionTopic crea il topic con partizioni, replication factor e config dei flag,
+// o verifica che il topic esistente corrisponda
+func provisionTopic(opts options, topic string) error {
+	configs, err := common.ParseOverrides(opts.topicConfig)
+	if err != nil {
+		return fmt.Errorf("invalid topic config: %w", err)
+	}
+	spec := common.TopicSpec{
+		Name:              topic,
+		Partitions:        opts.partitions,
+		ReplicationFactor: opts.replicationFactor,
+		Configs:           configs,
+	}
+	return common.EnsureTopic(constants.KafkaBootstrapServers, spec)
+}
+
+// loadAvroSchema restituisce lo schema Avro del file .avsc, o lo schema User predefinito se 'path' è vuoto
+func loadAvroSchema(path string) (string, error) {
+	if path == "" {
+		return utils.UserAvroSchema, nil
+	}
+	schema, err := utils.LoadAvroSchema(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to load Avro schema: %w", err)
+	}
+	return schema, nil
+}
+
+// newRecovery builds the retry policy and the dead letter of the failed deliveries from the flags
+func newRecovery(retries int, backoff time.Duration, topic, file string, tuning *common.Tuning) (*common.Recovery, error) {
+	recovery := &common.Recovery{Policy: common.DefaultRetryPolicy()}
+	recovery.Policy.MaxRetries, recovery.Policy.InitialBackoff = retries, backoff
+
+	var err error
+	switch {
+	case topic != "" && file != "":
+		return nil, fmt.Errorf("-dead-letter-topic and -dead-letter-file cannot be used together")
+	case topic != "":
+		recovery.DeadLetter, err = common.NewDeadLetterTopic(constants.KafkaBootstrapServers, topic, tuning)
+	case file != "":
+		recovery.DeadLetter, err = common.NewDeadLetterFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return recovery, nil
+}
+
+// saveCheckpoint writes the rows confirmed so far to the checkpoint file, if checkpoints are enabled
+func saveCheckpoint(cp *checkpoint.Checkpoint) {
+	if cp == nil {
+		return
+	}
+	if err := cp.Save(); err != nil {
+		logger.ErrorAsync("Error saving checkpoint: ", err)
+		return
+	}
+	logger.InfoAsync("Checkpoint saved at row ", cp.Offset())
 }
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"csvreader/internal/checkpoint"
+	"csvreader/internal/models"
+	"csvreader/internal/producer/avro"
+	"csvreader/internal/producer/common"
+	"csvreader/internal/producer/json"
+	"csvreader/internal/schemaregistry"
+	"csvreader/pkg/constants"
+	"csvreader/pkg/logger"
+	"csvreader/pkg/utils"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// TestMain scrive il log in un file temporaneo: il percorso di constants.LOGFILE non esiste nella directory del package
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "csv_app")
+	if err != nil {
+		fmt.Println("Errore durante la creazione della directory del log:", err)
+		os.Exit(1)
+	}
+	if err := logger.Init(filepath.Join(dir, "test.log")); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	logger.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testOptions restituisce le opzioni di default della run con input e file generati nella directory 'dir'
+func testOptions(dir, input string) options {
+	return options{
+		input:           input,
+		format:          "csv",
+		delimiter:       "|",
+		quarantineFile:  filepath.Join(dir, "rejected_rows.jsonl"),
+		maxErrors:       constants.MaxRejectedRows,
+		parseWorkers:    1,
+		ordered:         true,
+		checkpointFile:  filepath.Join(dir, "checkpoint.json"),
+		jsonFile:        filepath.Join(dir, "users.json"),
+		avroFile:        filepath.Join(dir, "avro_users.avro"),
+		avroCompression: "deflate",
+		avroBlockSize:   2,
+		batchSize:       2,
+	}
+}
+
+// headerMap restituisce gli header del messaggio come mappa
+func headerMap(msgHeaders []kafka.Header) map[string]string {
+	result := make(map[string]string, len(msgHeaders))
+	for _, header := range msgHeaders {
+		result[header.Key] = string(header.Value)
+	}
+	return result
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "users.csv")
+	data := "id|nome_utente|email\n1|user1|user1@example.com\n2|user2|user2@example.com\n3|user3|user3@example.com\n"
+	if err := os.WriteFile(input, []byte(data), 0644); err != nil {
+		t.Fatalf("Errore durante la scrittura del file di input: %v", err)
+	}
+
+	keying, err := common.NewKeying(common.KeyID, "")
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del keying: %v", err)
+	}
+	headers, err := common.ParseHeaders("all", time.Date(2024, 7, 17, 5, 22, 43, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Errore durante la lettura degli header: %v", err)
+	}
+	sink := common.NewMemorySink(producer.NewMessageBuilder(constants.KafkaTopic, keying, headers))
+
+	opts := testOptions(dir, input)
+	if err := run(opts, sink, "correlation"); err != nil {
+		t.Fatalf("Errore inatteso dalla run: %v", err)
+	}
+
+	messages := sink.Messages()
+	if len(messages) != 3 {
+		t.Fatalf("Messaggi attesi: 3, ottenuti: %d", len(messages))
+	}
+	expectedValues := []string{
+		`{"id":1,"nome_utente":"user1","email":"user1@example.com"}`,
+		`{"id":2,"nome_utente":"user2","email":"user2@example.com"}`,
+		`{"id":3,"nome_utente":"user3","email":"user3@example.com"}`,
+	}
+	for i, msg := range messages {
+		if string(msg.Value) != expectedValues[i] {
+			t.Errorf("Payload atteso: %s, ottenuto: %s", expectedValues[i], msg.Value)
+		}
+		if string(msg.Key) != strconv.Itoa(i+1) {
+			t.Errorf("Chiave attesa: %d, ottenuta: %s", i+1, msg.Key)
+		}
+		if *msg.TopicPartition.Topic != constants.KafkaTopic {
+			t.Errorf("Topic atteso: %s, ottenuto: %s", constants.KafkaTopic, *msg.TopicPartition.Topic)
+		}
+		h := headerMap(msg.Headers)
+		expectedHeaders := map[string]string{
+			common.HeaderCorrelationID: "correlation",
+			common.HeaderSourceFile:    input,
+			common.HeaderSourceLine:    strconv.Itoa(i + 2),
+			common.HeaderSchemaVersion: producer.Format.SchemaVersion,
+			common.HeaderContentType:   "application/json",
+			common.HeaderRunStart:      "2024-07-17T05:22:43Z",
+			common.HeaderChecksum:      fmt.Sprintf("%08x", crc32.Checksum(msg.Value, crc32.MakeTable(crc32.Castagnoli))),
+		}
+		for name, value := range expectedHeaders {
+			if h[name] != value {
+				t.Errorf("Header %s atteso: %q, ottenuto: %q", name, value, h[name])
+			}
+		}
+		if h[common.HeaderHostname] == "" {
+			t.Errorf("Header %s mancante", common.HeaderHostname)
+		}
+	}
+	if stats := sink.Stats(); stats.Produced != 3 || stats.Delivered != 3 {
+		t.Errorf("Statistiche inattese: %v", stats)
+	}
+
+	// La run salva il checkpoint e scrive i file JSON e Avro
+	offset, err := checkpoint.Load(opts.checkpointFile, input)
+	if err != nil || offset != 3 {
+		t.Errorf("Checkpoint atteso alla riga 3, ottenuto: %d, %v", offset, err)
+	}
+	for _, file := range []string{opts.jsonFile, opts.avroFile} {
+		if info, err := os.Stat(file); err != nil || info.Size() == 0 {
+			t.Errorf("File generato %s mancante o vuoto: %v", file, err)
+		}
+	}
+	if avroUsers, err := utils.ReadAvroFile(opts.avroFile, nil); err != nil || len(avroUsers) != 3 {
+		t.Errorf("Attesi 3 utenti nel file Avro, ottenuti: %v, %v", avroUsers, err)
+	}
+
+	// Con -resume le righe già consegnate non vengono inviate di nuovo
+	resumed := common.NewMemorySink(producer.NewMessageBuilder(constants.KafkaTopic, nil, nil))
+	opts.resume = true
+	if err := run(opts, resumed, "correlation"); err != nil {
+		t.Fatalf("Errore inatteso dalla run ripresa: %v", err)
+	}
+	if len(resumed.Messages()) != 0 {
+		t.Errorf("Nessun messaggio atteso alla ripresa, ottenuti: %d", len(resumed.Messages()))
+	}
+}
+
+func TestRunTolerant(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "users.csv")
+	data := "id|nome_utente|email\n1|user1|user1@example.com\nxx|user2|user2@example.com\n3|user3|not-an-email\n4|user4|user4@example.com\n"
+	if err := os.WriteFile(input, []byte(data), 0644); err != nil {
+		t.Fatalf("Errore durante la scrittura del file di input: %v", err)
+	}
+
+	sink := common.NewMemorySink(producer.NewMessageBuilder(constants.KafkaTopic, nil, nil))
+	opts := testOptions(dir, input)
+	opts.validate = "default"
+	if err := run(opts, sink, "correlation"); err != nil {
+		t.Fatalf("Errore inatteso dalla run: %v", err)
+	}
+
+	// La riga malformata e quella non valida vanno in quarantena, non a Kafka
+	if len(sink.Messages()) != 2 {
+		t.Errorf("Messaggi attesi: 2, ottenuti: %d", len(sink.Messages()))
+	}
+
+	// Il parsing parallelo non conosce le righe da scrivere in quarantena
+	parallel := opts
+	parallel.parseWorkers = 4
+	if err := run(parallel, sink, "correlation"); err == nil {
+		t.Errorf("Atteso errore per -parse-workers con -validate, ma non si è verificato")
+	}
+	parallel.validate = ""
+	parallel.headers = common.HeaderSourceLine
+	if err := run(parallel, sink, "correlation"); err == nil {
+		t.Errorf("Atteso errore per -parse-workers con l'header source-line, ma non si è verificato")
+	}
+
+	// Un input inesistente interrompe la run prima di inviare qualsiasi messaggio
+	opts.input = filepath.Join(dir, "missing.csv")
+	if err := run(opts, sink, "correlation"); err == nil {
+		t.Errorf("Atteso errore per un input inesistente, ma non si è verificato")
+	}
+}
+
+func TestRunFailedBatch(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "users.csv")
+	data := "id|nome_utente|email\n1|user1|user1@example.com\n2|user2|user2@example.com\n3|user3|user3@example.com\n4|user4|user4@example.com\n5|user5|user5@example.com\n"
+	if err := os.WriteFile(input, []byte(data), 0644); err != nil {
+		t.Fatalf("Errore durante la scrittura del file di input: %v", err)
+	}
+
+	// Il primo batch (utenti 1 e 2) fallisce sul secondo utente, come per un broker non disponibile
+	build := producer.NewMessageBuilder(constants.KafkaTopic, nil, nil)
+	sink := common.NewMemorySink(func(user models.User, correlationID string) (*kafka.Message, error) {
+		if user.ID == 2 {
+			return nil, fmt.Errorf("broker non disponibile")
+		}
+		return build(user, correlationID)
+	})
+
+	opts := testOptions(dir, input)
+	if err := run(opts, sink, "correlation"); err == nil {
+		t.Errorf("Atteso errore per il batch fallito, ma non si è verificato")
+	}
+
+	// I batch successivi vengono comunque prodotti
+	var ids []int
+	for _, msg := range sink.Messages() {
+		var user models.User
+		if err := json.Unmarshal(msg.Value, &user); err != nil {
+			t.Fatalf("Errore durante la decodifica del messaggio: %v", err)
+		}
+		ids = append(ids, user.ID)
+	}
+	if fmt.Sprint(ids) != "[1 3 4 5]" {
+		t.Errorf("Utenti prodotti attesi: [1 3 4 5], ottenuti: %v", ids)
+	}
+
+	// Il checkpoint resta prima della riga non consegnata, così una ripresa la invia di nuovo
+	offset, err := checkpoint.Load(opts.checkpointFile, input)
+	if err != nil || offset != 1 {
+		t.Errorf("Checkpoint atteso alla riga 1, ottenuto: %d, %v", offset, err)
+	}
+}
+
+func TestRunWireFormats(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "users.csv")
+	data := "id|nome_utente|email\n1|user1|user1@example.com\n2|user2|user2@example.com\n3|user3|user3@example.com\n"
+	if err := os.WriteFile(input, []byte(data), 0644); err != nil {
+		t.Fatalf("Errore durante la scrittura del file di input: %v", err)
+	}
+
+	// -wire-format both: i messaggi JSON e quelli Avro con lo schema ID della registry vanno su topic separati
+	encoder, err := utils.NewAvroUserEncoder(utils.UserAvroSchema, nil)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione dell'encoder Avro: %v", err)
+	}
+	jsonSink := common.NewMemorySink(producer.NewMessageBuilder(constants.KafkaTopic, nil, nil))
+	avroSink := common.NewMemorySink(avro.NewMessageBuilder(constants.KafkaAvroTopic, encoder, 7, nil, nil))
+	sink := common.NewMultiSink(jsonSink, avroSink)
+
+	opts := testOptions(dir, input)
+	if err := run(opts, sink, "correlation"); err != nil {
+		t.Fatalf("Errore inatteso dalla run: %v", err)
+	}
+	if len(jsonSink.Messages()) != 3 || len(avroSink.Messages()) != 3 {
+		t.Fatalf("Attesi 3 messaggi per formato, ottenuti: %d JSON e %d Avro", len(jsonSink.Messages()), len(avroSink.Messages()))
+	}
+	for i, msg := range avroSink.Messages() {
+		if *msg.TopicPartition.Topic != constants.KafkaAvroTopic {
+			t.Errorf("Topic atteso: %s, ottenuto: %s", constants.KafkaAvroTopic, *msg.TopicPartition.Topic)
+		}
+		id, payload, err := schemaregistry.Unframe(msg.Value)
+		if err != nil || id != 7 {
+			t.Fatalf("Atteso payload in wire format con ID 7, ottenuti: %d, %v", id, err)
+		}
+		native, _, err := encoder.Codec().NativeFromBinary(payload)
+		if err != nil {
+			t.Fatalf("Errore durante la decodifica del payload Avro: %v", err)
+		}
+		if user, err := encoder.Decode(native); err != nil || user.ID != i+1 {
+			t.Errorf("Utente %d atteso nel messaggio Avro, ottenuto: %v, %v", i+1, user, err)
+		}
+	}
+	if stats := sink.Stats(); stats.Produced != 6 || stats.Delivered != 6 {
+		t.Errorf("Statistiche inattese: %v", stats)
+	}
+	offset, err := checkpoint.Load(opts.checkpointFile, input)
+	if err != nil || offset != 3 {
+		t.Errorf("Checkpoint atteso alla riga 3, ottenuto: %d, %v", offset, err)
+	}
+
+	// Le combinazioni di flag non valide vengono rifiutate prima di creare i producer
+	for _, invalid := range []options{
+		{wireFormat: "xml"},
+		{wireFormat: wireAvro, async: true},
+		{wireFormat: wireProtobuf, async: true},
+		{wireFormat: wireJSON, async: true, transactional: true},
+	} {
+		if _, _, err := newSink(invalid, "correlation", time.Now()); err == nil {
+			t.Errorf("Atteso errore per le opzioni %+v, ma non si è verificato", invalid)
+		}
+	}
+}
@@ -5,14 +5,15 @@ go 1.22
 require (
 	github.com/confluentinc/confluent-kafka-go/v2 v2.5.0
 	github.com/google/uuid v1.6.0
+	github.com/klauspost/compress v1.17.9
 	github.com/linkedin/goavro/v2 v2.13.0
 	github.com/petermattis/goid v0.0.0-20240711130651-8c0f67b704fe
 	github.com/pquerna/ffjson v0.0.0-20190930134022-aa0246cd15f7
+	google.golang.org/protobuf v1.33.0
 )
 
 require (
 	github.com/golang/snappy v0.0.4 // indirect
-	github.com/klauspost/compress v1.17.9 // indirect
 	golang.org/x/crypto v0.25.0 // indirect
 	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
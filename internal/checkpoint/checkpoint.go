@@ -0,0 +1,135 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// state è il contenuto del file di checkpoint
+type state struct {
+	Input     string    `json:"input"`      // input della run che ha scritto il checkpoint
+	Offset    int64     `json:"offset"`     // ultima riga (1-based, sullo stream di tutti i file) con consegna confermata
+	UpdatedAt time.Time `json:"updated_at"` // momento dell'ultimo salvataggio
+}
+
+// Checkpoint tiene traccia delle righe la cui consegna a Kafka è stata confermata e le salva in un file locale.
+// Le conferme possono arrivare in qualsiasi ordine (partizioni diverse): l'offset salvato è sempre
+// l'ultima riga prima della quale tutte le consegne sono state confermate, così una ripresa non perde nessuna riga.
+type Checkpoint struct {
+	mu        sync.Mutex
+	path      string
+	input     string
+	watermark int64         // tutte le righe fino a questa sono confermate
+	confirmed map[int64]int // conferme delle righe oltre il watermark, in attesa di quelle precedenti
+	required  int           // conferme necessarie per considerare consegnata una riga (vedi RequireConfirmations)
+	saved     int64         // watermark dell'ultimo salvataggio
+}
+
+// New creates a checkpoint stored at 'path' for the run reading 'input', starting from row 'offset'
+// (0 for a fresh run, the loaded offset when resuming). Nothing is written until Save is called.
+func New(path, input string, offset int64) *Checkpoint {
+	return &Checkpoint{
+		path:      path,
+		input:     input,
+		watermark: offset,
+		confirmed: make(map[int64]int),
+		required:  1,
+		saved:     -1,
+	}
+}
+
+// Load reads the checkpoint file at 'path' and returns the last confirmed row offset.
+// It returns 0 if the file does not exist, and an error if the file is unreadable
+// or was written by a run reading a different input.
+func Load(path, input string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("errore durante la lettura del checkpoint %s: %v", path, err)
+	}
+
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, fmt.Errorf("checkpoint %s non valido: %v", path, err)
+	}
+	if s.Input != input {
+		return 0, fmt.Errorf("il checkpoint %s appartiene all'input %q, non a %q", path, s.Input, input)
+	}
+	return s.Offset, nil
+}
+
+// RequireConfirmations makes a row count as delivered only once it has been confirmed 'n' times,
+// e.g. once by each producer when every row is sent to several topics. It must be called before any Confirm.
+func (c *Checkpoint) RequireConfirmations(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.required = max(n, 1)
+}
+
+// Confirm marks the row 'offset' as delivered. It must only be called for successful delivery reports.
+func (c *Checkpoint) Confirm(offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if offset <= c.watermark {
+		return
+	}
+	c.confirmed[offset]++
+	for c.confirmed[c.watermark+1] >= c.required {
+		delete(c.confirmed, c.watermark+1)
+		c.watermark++
+	}
+}
+
+// Offset returns the last row offset before which every delivery has been confirmed.
+func (c *Checkpoint) Offset() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.watermark
+}
+
+// Save writes the current offset to the checkpoint file, if it changed since the last save.
+// The file is replaced atomically, so a crash during the write never leaves a truncated checkpoint.
+func (c *Checkpoint) Save() error {
+	c.mu.Lock()
+	offset := c.watermark
+	if offset == c.saved {
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(state{Input: c.input, Offset: offset, UpdatedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("errore durante la conversione in JSON del checkpoint: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("errore durante la scrittura del checkpoint: %v", err)
+	}
+	defer os.Remove(tmp.Name()) // dopo la rename non esiste più, l'errore viene ignorato
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("errore durante la scrittura del checkpoint: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("errore durante la scrittura del checkpoint: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("errore durante la scrittura del checkpoint: %v", err)
+	}
+
+	c.mu.Lock()
+	c.saved = offset
+	c.mu.Unlock()
+	return nil
+}
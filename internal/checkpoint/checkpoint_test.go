@@ -0,0 +1,64 @@
+package checkpoint
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	// Nessun file: si parte dall'inizio
+	offset, err := Load(path, "users.csv")
+	if err != nil || offset != 0 {
+		t.Fatalf("Offset atteso: 0, ottenuto: %d (errore: %v)", offset, err)
+	}
+
+	// Le conferme fuori ordine non fanno avanzare il checkpoint oltre una riga non ancora consegnata
+	cp := New(path, "users.csv", 0)
+	cp.Confirm(2)
+	cp.Confirm(3)
+	if cp.Offset() != 0 {
+		t.Errorf("Offset atteso: 0, ottenuto: %d", cp.Offset())
+	}
+	cp.Confirm(1)
+	cp.Confirm(5)
+	if cp.Offset() != 3 {
+		t.Errorf("Offset atteso: 3, ottenuto: %d", cp.Offset())
+	}
+
+	if err := cp.Save(); err != nil {
+		t.Fatalf("Errore durante il salvataggio del checkpoint: %v", err)
+	}
+	offset, err = Load(path, "users.csv")
+	if err != nil || offset != 3 {
+		t.Errorf("Offset atteso: 3, ottenuto: %d (errore: %v)", offset, err)
+	}
+
+	// Un checkpoint di un altro input non può essere usato
+	if _, err := Load(path, "exports/users_*.csv"); err == nil {
+		t.Errorf("Atteso errore per un checkpoint di un altro input, ma non si è verificato")
+	}
+
+	// Ripresa: le righe già consegnate vengono ignorate
+	resumed := New(path, "users.csv", 3)
+	resumed.Confirm(2)
+	resumed.Confirm(4)
+	if resumed.Offset() != 4 {
+		t.Errorf("Offset atteso: 4, ottenuto: %d", resumed.Offset())
+	}
+
+	// Con due producer ogni riga deve essere confermata da entrambi
+	both := New(path, "users.csv", 0)
+	both.RequireConfirmations(2)
+	both.Confirm(1)
+	both.Confirm(2)
+	both.Confirm(1)
+	if both.Offset() != 1 {
+		t.Errorf("Offset atteso: 1, ottenuto: %d", both.Offset())
+	}
+	both.Confirm(2)
+	if both.Offset() != 2 {
+		t.Errorf("Offset atteso: 2, ottenuto: %d", both.Offset())
+	}
+}
@@ -1,7 +1,19 @@
 package models
 
 type User struct {
-	ID         int    `json:"id" :"id"`
-	NomeUtente string `json:"nome_utente" :"nome_utente"`
-	Email      string `json:"email" :"email"`
+	ID         int    `json:"id"`
+	NomeUtente string `json:"nome_utente"`
+	Email      string `json:"email"`
+
+	// SourceFile è il file di input da cui proviene l'utente: non fa parte del payload,
+	// viene inviato come header del messaggio Kafka
+	SourceFile string `json:"-"`
+
+	// SourceLine è il numero di riga (1-based) dell'utente nel file di input, 0 se non noto
+	// (es. con il parsing parallelo a chunk): anche questo viene inviato solo come header
+	SourceLine int `json:"-"`
+
+	// RowOffset è la posizione (1-based) dell'utente nello stream di tutti i file di input,
+	// usata dai checkpoint per riprendere una run interrotta
+	RowOffset int64 `json:"-"`
 }
@@ -0,0 +1,22 @@
+package userpb
+
+import (
+	"csvreader/internal/models"
+	_ "embed"
+)
+
+// Schema è la definizione .proto del messaggio User, registrata nella Schema Registry come schema PROTOBUF
+//
+//go:embed user.proto
+var Schema string
+
+// FromUser converts a models.User into its Protobuf message. The provenance fields are not serialized,
+// like in the JSON and Avro payloads. The id is an int64, so every ID keeps the value of the other payloads.
+func FromUser(user models.User) *User {
+	return &User{Id: int64(user.ID), NomeUtente: user.NomeUtente, Email: user.Email}
+}
+
+// ToUser converts the Protobuf message back into a models.User.
+func (x *User) ToUser() models.User {
+	return models.User{ID: int(x.GetId()), NomeUtente: x.GetNomeUtente(), Email: x.GetEmail()}
+}
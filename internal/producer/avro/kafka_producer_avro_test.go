@@ -0,0 +1,138 @@
+package avro
+
+import (
+	"csvreader/internal/models"
+	"csvreader/internal/schemaregistry"
+	"csvreader/pkg/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func TestSchemaRegistryFraming(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del mock cluster: %v", err)
+	}
+	defer cluster.Close()
+	if err := cluster.CreateTopic("users", 1, 1); err != nil {
+		t.Fatalf("Errore durante la creazione del topic: %v", err)
+	}
+	registry := schemaregistry.NewMockRegistry()
+	defer registry.Close()
+
+	p, err := NewProducerAvro(cluster.BootstrapServers(), "users", utils.UserAvroSchema, nil, nil)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del producer: %v", err)
+	}
+	defer p.Close()
+	client := schemaregistry.NewClient(registry.URL())
+	if err := p.SetSchemaRegistry(client, schemaregistry.ValueSubject("users"), true); err != nil {
+		t.Fatalf("Errore durante la registrazione dello schema: %v", err)
+	}
+
+	user := models.User{ID: 7, NomeUtente: "mario", Email: "mario@example.com"}
+	if err := p.ProduceBatch([]models.User{user}, "correlation"); err != nil {
+		t.Fatalf("Errore durante la produzione del batch: %v", err)
+	}
+	// Anche i payload già codificati di ProduceBatchAvro vengono incorniciati con lo schema ID
+	avroData, err := p.ConvertUsersToAvro([]models.User{user})
+	if err != nil {
+		t.Fatalf("Errore durante la codifica Avro: %v", err)
+	}
+	if err := p.ProduceBatchAvro(avroData, "correlation"); err != nil {
+		t.Fatalf("Errore durante la produzione del batch Avro: %v", err)
+	}
+
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": cluster.BootstrapServers(),
+		"group.id":          "test",
+		"auto.offset.reset": "earliest",
+	})
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del consumer: %v", err)
+	}
+	defer consumer.Close()
+	if err := consumer.Subscribe("users", nil); err != nil {
+		t.Fatalf("Errore durante la sottoscrizione al topic: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		msg, err := consumer.ReadMessage(10 * time.Second)
+		if err != nil {
+			t.Fatalf("Errore durante la lettura del messaggio %d: %v", i, err)
+		}
+		id, payload, err := schemaregistry.Unframe(msg.Value)
+		if err != nil || id != 1 {
+			t.Fatalf("Atteso payload in wire format con ID 1, ottenuti: %d, %v", id, err)
+		}
+
+		// Un consumer decodifica il payload con lo schema registrato sotto l'ID
+		native, _, err := p.avroEncoder.Codec().NativeFromBinary(payload)
+		if err != nil {
+			t.Fatalf("Errore durante la decodifica del payload: %v", err)
+		}
+		record := native.(map[string]interface{})
+		if record["ID"] != int32(7) || record["Email"] != "mario@example.com" {
+			t.Errorf("Record decodificato inatteso: %v", record)
+		}
+	}
+}
+
+func TestInferredSchemaRenamedHeaders(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del mock cluster: %v", err)
+	}
+	defer cluster.Close()
+	if err := cluster.CreateTopic("users", 1, 1); err != nil {
+		t.Fatalf("Errore durante la creazione del topic: %v", err)
+	}
+
+	// Lo schema inferito da un CSV con le colonne rinominate usa i loro nomi, associati a User con -columns
+	schema, err := utils.InferAvroSchema(strings.NewReader("user_id|user name|mail\n7|mario|mario@example.com\n"), utils.DefaultCSVConfig(), 0, "User", "")
+	if err != nil {
+		t.Fatalf("Errore durante l'inferenza dello schema: %v", err)
+	}
+	mapping, err := utils.ParseColumnMapping("user_id=ID,user name=NomeUtente,mail=Email")
+	if err != nil {
+		t.Fatalf("Errore durante il parsing della mappatura: %v", err)
+	}
+	p, err := NewProducerAvro(cluster.BootstrapServers(), "users", schema, mapping, nil)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del producer: %v", err)
+	}
+	defer p.Close()
+
+	user := models.User{ID: 7, NomeUtente: "mario", Email: "mario@example.com"}
+	if err := p.ProduceBatch([]models.User{user}, "correlation"); err != nil {
+		t.Fatalf("Errore durante la produzione del batch: %v", err)
+	}
+
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": cluster.BootstrapServers(),
+		"group.id":          "test",
+		"auto.offset.reset": "earliest",
+	})
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del consumer: %v", err)
+	}
+	defer consumer.Close()
+	if err := consumer.Subscribe("users", nil); err != nil {
+		t.Fatalf("Errore durante la sottoscrizione al topic: %v", err)
+	}
+	msg, err := consumer.ReadMessage(10 * time.Second)
+	if err != nil {
+		t.Fatalf("Errore durante la lettura del messaggio: %v", err)
+	}
+	native, _, err := p.avroEncoder.Codec().NativeFromBinary(msg.Value)
+	if err != nil {
+		t.Fatalf("Errore durante la decodifica del payload: %v", err)
+	}
+	record := native.(map[string]interface{})
+	if record["user_id"] != int32(7) || record["user_name"] != "mario" || record["mail"] != "mario@example.com" {
+		t.Errorf("Record decodificato inatteso: %v", record)
+	}
+}
@@ -2,104 +2,150 @@ package avro
 
 import (
 	"csvreader/internal/models"
+	"csvreader/internal/producer/common"
+	"csvreader/internal/schemaregistry"
 	"csvreader/pkg/logger"
+	"csvreader/pkg/utils"
 	"fmt"
-	"sync"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
-	"github.com/linkedin/goavro/v2"
 )
 
+// Producer produce gli utenti codificati in Avro (vedi NewMessageBuilder): produzione, delivery report,
+// checkpoint e transazioni sono quelli di common.KafkaSink
 type Producer struct {
-	producer     *kafka.Producer
-	topic        string
-	deliveryChan chan kafka.Event
-	avroCodec    *goavro.Codec
+	*common.KafkaSink
+	avroEncoder *utils.AvroUserEncoder
+	schemaID    int // ID nella Schema Registry, 0 = payload Avro senza wire format (vedi SetSchemaRegistry)
 }
 
-func NewProducerAvro(bootstrapServers, topic, avroSchema string) (*Producer, error) {
-	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": bootstrapServers})
+// Producer implementa common.Sink
+var _ common.Sink = (*Producer)(nil)
+
+// NewProducerAvro creates a new Kafka producer that encodes users with 'avroSchema',
+// either utils.UserAvroSchema or a schema loaded from a .avsc file with utils.LoadAvroSchema, whose fields are
+// matched to the User fields with the CSV's column 'mapping' (see utils.NewAvroUserEncoder, nil = default columns).
+// 'tuning' selects the librdkafka tuning profile and overrides (nil = librdkafka defaults).
+func NewProducerAvro(bootstrapServers, topic, avroSchema string, mapping utils.ColumnMapping, tuning *common.Tuning) (*Producer, error) {
+	encoder, err := utils.NewAvroUserEncoder(avroSchema, mapping)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create producer: %w", err)
+		return nil, fmt.Errorf("failed to create Avro codec: %v", err)
 	}
 
-	codec, err := goavro.NewCodec(avroSchema)
+	sink, err := common.NewKafkaSink(bootstrapServers, topic, tuning, newBuilder(encoder, 0))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Avro codec: %v", err)
+		return nil, err
 	}
+	return &Producer{KafkaSink: sink, avroEncoder: encoder}, nil
+}
 
-	logger.InfoAsync("Kafka producer created successfully")
+// NewMessageBuilder returns the builder of the messages produced to 'topic': the user encoded in binary Avro
+// with 'encoder', framed in Confluent wire format with 'schemaID' (0 = bare Avro binary), keyed according
+// to 'keying' (nil = no key), with a correlation-id header followed by the provenance headers selected by
+// 'headers' (nil = source-file only).
+// It is exported so that a common.MemorySink can record exactly the messages the producer would send.
+func NewMessageBuilder(topic string, encoder *utils.AvroUserEncoder, schemaID int, keying *common.Keying, headers *common.Headers) common.MessageBuilder {
+	format := Format(encoder)
+	return func(user models.User, correlationID string) (*kafka.Message, error) {
+		payload, err := encoder.Encode(nil, user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode Avro record: %v", err)
+		}
+		if schemaID > 0 {
+			payload = schemaregistry.Frame(schemaID, payload)
+		}
 
-	return &Producer{
-		producer:     p,
-		topic:        topic,
-		deliveryChan: make(chan kafka.Event, 1000), // Increased buffer size
-		avroCodec:    codec,
-	}, nil
+		key, partition := keying.Route(user)
+		return &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition},
+			Key:            key,
+			Value:          payload,
+			Headers:        headers.Build(correlationID, &user, payload, format),
+			Opaque:         user.RowOffset,
+		}, nil
+	}
 }
 
-func (p *Producer) ProduceBatchAvro(avroData [][]byte, correlationID string) error {
-	logger.InfoAsync("Starting batch production")
-
-	var wg sync.WaitGroup
-	for _, data := range avroData {
-		wg.Add(1)
-		go func(data []byte) {
-			defer wg.Done()
-			err := p.produceAvroMessage(data, correlationID)
-			if err != nil {
-				logger.ErrorAsync("Produce failed:", err)
-			}
-		}(data)
+// newBuilder restituisce la common.BuilderFactory dei messaggi Avro codificati con 'encoder' e incorniciati con 'schemaID'
+func newBuilder(encoder *utils.AvroUserEncoder, schemaID int) common.BuilderFactory {
+	return func(topic string, keying *common.Keying, headers *common.Headers) common.MessageBuilder {
+		return NewMessageBuilder(topic, encoder, schemaID, keying, headers)
 	}
+}
 
-	wg.Wait()
+// NewTransactionalProducerAvro creates an Avro producer in transactional mode with 'transactionalID':
+// every ProduceBatch call is committed as one Kafka transaction and aborted on any delivery error.
+func NewTransactionalProducerAvro(bootstrapServers, topic, avroSchema string, mapping utils.ColumnMapping, tuning *common.Tuning, transactionalID string) (*Producer, error) {
+	encoder, err := utils.NewAvroUserEncoder(avroSchema, mapping)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Avro codec: %v", err)
+	}
 
-	// Ensure all delivery reports are processed
-	for range avroData {
-		if err := p.waitForAvroDeliveryReport(); err != nil {
-			return err
-		}
+	sink, err := common.NewTransactionalKafkaSink(bootstrapServers, topic, tuning, transactionalID, newBuilder(encoder, 0))
+	if err != nil {
+		return nil, err
 	}
+	return &Producer{KafkaSink: sink, avroEncoder: encoder}, nil
+}
 
-	logger.InfoAsync("Batch production completed")
+// SetSchemaRegistry makes the producer frame every payload in Confluent wire format (magic byte and 4-byte schema ID),
+// so that standard consumers can decode it. The ID of the producer's schema under 'subject'
+// (e.g. schemaregistry.ValueSubject(topic)) is looked up in the registry, registering the schema
+// first if 'autoRegister' is set.
+func (p *Producer) SetSchemaRegistry(client *schemaregistry.Client, subject string, autoRegister bool) error {
+	id, err := client.SchemaID(subject, schemaregistry.Schema{Schema: p.avroEncoder.Codec().Schema()}, autoRegister)
+	if err != nil {
+		return err
+	}
+	logger.InfoAsync("Avro payloads framed with schema ID ", id, " of subject ", subject)
+	p.schemaID = id
+	p.SetBuilder(newBuilder(p.avroEncoder, p.schemaID))
 	return nil
 }
 
-func (p *Producer) produceAvroMessage(payload []byte, correlationID string) error {
-	return p.producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &p.topic, Partition: kafka.PartitionAny},
-		Value:          payload,
-		Headers:        []kafka.Header{{Key: "correlation-id", Value: []byte(correlationID)}},
-	}, p.deliveryChan)
+// CheckCompatibility checks the producer's schema against the versions registered under 'subject'
+// according to 'level', so that a schema change that would break the existing consumers is caught
+// before anything is sent. The error lists the incompatible fields of each version.
+func (p *Producer) CheckCompatibility(client *schemaregistry.Client, subject string, level schemaregistry.Compatibility) error {
+	return client.CheckCompatibility(subject, schemaregistry.Schema{Schema: p.avroEncoder.Codec().Schema()}, level)
 }
 
-func (p *Producer) waitForAvroDeliveryReport() error {
-	e := <-p.deliveryChan
-	m := e.(*kafka.Message)
-
-	if m.TopicPartition.Error != nil {
-		logger.ErrorAsync("Delivery failed:", m.TopicPartition.Error)
-		return fmt.Errorf("delivery failed: %w", m.TopicPartition.Error)
+// Format returns the format of the payloads encoded by 'encoder': the schema-version header is the
+// Rabin fingerprint of the schema, which identifies it regardless of its formatting.
+func Format(encoder *utils.AvroUserEncoder) common.PayloadFormat {
+	return common.PayloadFormat{
+		ContentType:   "application/avro",
+		SchemaVersion: fmt.Sprintf("%016x", encoder.Codec().Rabin),
 	}
+}
 
-	return nil
+// ProduceBatchAvro produces payloads already encoded with ConvertUsersToAvro, framed like the ones of
+// ProduceBatch, and waits for their delivery reports. The messages have no key and no row offset,
+// so they do not move the checkpoint.
+func (p *Producer) ProduceBatchAvro(avroData [][]byte, correlationID string) error {
+	topic := p.Topic()
+	format := Format(p.avroEncoder)
+	messages := make([]*kafka.Message, len(avroData))
+	for i, payload := range avroData {
+		if p.schemaID > 0 {
+			payload = schemaregistry.Frame(p.schemaID, payload)
+		}
+		messages[i] = &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+			Value:          payload,
+			Headers:        p.Headers().Build(correlationID, nil, payload, format),
+		}
+	}
+	return p.ProduceMessages(messages)
 }
 
+// CloseAvro closes the producer, like Close.
 func (p *Producer) CloseAvro() {
-	logger.InfoAsync("Closing producer")
-	close(p.deliveryChan)
-	p.producer.Close()
+	p.Close()
 }
 
 func (p *Producer) ConvertUserToAvro(user *models.User) ([]byte, error) {
-	avroRecord := map[string]interface{}{
-		"ID":         user.ID,
-		"NomeUtente": user.NomeUtente,
-		"Email":      user.Email,
-	}
-
-	binary, err := p.avroCodec.BinaryFromNative(nil, avroRecord)
+	binary, err := p.avroEncoder.Encode(nil, *user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode Avro record: %v", err)
 	}
@@ -0,0 +1,127 @@
+package common
+
+import (
+	"csvreader/internal/models"
+	"csvreader/pkg/constants"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Header dei messaggi: correlation-id è sempre presente, gli altri sono header di provenienza opzionali
+const (
+	HeaderCorrelationID = "correlation-id"
+	HeaderSourceFile    = constants.SourceFileHeader // file di input dell'utente
+	HeaderSourceLine    = "source-line"              // riga dell'utente nel file di input, se nota
+	HeaderSchemaVersion = "schema-version"           // versione del formato JSON o fingerprint dello schema Avro
+	HeaderContentType   = "content-type"             // es. application/json
+	HeaderHostname      = "producer-hostname"        // host che ha prodotto il messaggio
+	HeaderRunStart      = "run-started-at"           // inizio della run, RFC 3339 in UTC
+	HeaderChecksum      = "checksum"                 // CRC-32C esadecimale del payload
+)
+
+// provenanceHeaders sono gli header opzionali nell'ordine in cui vengono aggiunti ai messaggi
+var provenanceHeaders = []string{
+	HeaderSourceFile, HeaderSourceLine, HeaderSchemaVersion, HeaderContentType,
+	HeaderHostname, HeaderRunStart, HeaderChecksum,
+}
+
+// crc32c è la tabella di CRC-32C (Castagnoli), lo stesso checksum usato da Kafka per i record batch
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// PayloadFormat descrive il formato del payload di un producer, per gli header content-type e schema-version
+type PayloadFormat struct {
+	ContentType   string
+	SchemaVersion string
+}
+
+// Headers è l'insieme degli header di provenienza scelti per una run.
+// Il valore nil aggiunge solo source-file, come le versioni precedenti.
+type Headers struct {
+	enabled  map[string]bool
+	hostname string
+	runStart string
+}
+
+// ParseHeaders parses the comma-separated list of provenance headers to add to every message, e.g.
+// "source-file,source-line,checksum", or "all" for every header, or "none". 'runStart' is the value
+// of the run-started-at header, the hostname is read from the system.
+func ParseHeaders(spec string, runStart time.Time) (*Headers, error) {
+	h := &Headers{enabled: make(map[string]bool), runStart: runStart.UTC().Format(time.RFC3339Nano)}
+
+	switch spec = strings.TrimSpace(spec); spec {
+	case "none":
+	case "all":
+		for _, name := range provenanceHeaders {
+			h.enabled[name] = true
+		}
+	default:
+		for _, name := range strings.Split(spec, ",") {
+			name = strings.TrimSpace(name)
+			if !isProvenanceHeader(name) {
+				return nil, fmt.Errorf("unknown header %q: use all, none or a list of %s", name, strings.Join(provenanceHeaders, ", "))
+			}
+			h.enabled[name] = true
+		}
+	}
+
+	if h.enabled[HeaderHostname] {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read hostname: %w", err)
+		}
+		h.hostname = hostname
+	}
+	return h, nil
+}
+
+func isProvenanceHeader(name string) bool {
+	for _, header := range provenanceHeaders {
+		if name == header {
+			return true
+		}
+	}
+	return false
+}
+
+// Has reports whether the header 'name' is added to the messages.
+func (h *Headers) Has(name string) bool {
+	if h == nil {
+		return name == HeaderSourceFile
+	}
+	return h.enabled[name]
+}
+
+// Build returns the headers of a message: the correlation ID followed by the enabled provenance headers.
+// 'user' may be nil when only the payload is known (see ProduceBatchAvro): the headers describing
+// the input row are then omitted, like the ones whose value is not known (e.g. the line of a chunked read).
+func (h *Headers) Build(correlationID string, user *models.User, payload []byte, format PayloadFormat) []kafka.Header {
+	headers := []kafka.Header{{Key: HeaderCorrelationID, Value: []byte(correlationID)}}
+	add := func(name, value string) {
+		if value != "" && h.Has(name) {
+			headers = append(headers, kafka.Header{Key: name, Value: []byte(value)})
+		}
+	}
+
+	if user != nil {
+		add(HeaderSourceFile, user.SourceFile)
+		if user.SourceLine > 0 {
+			add(HeaderSourceLine, strconv.Itoa(user.SourceLine))
+		}
+	}
+	add(HeaderSchemaVersion, format.SchemaVersion)
+	add(HeaderContentType, format.ContentType)
+	if h != nil {
+		add(HeaderHostname, h.hostname)
+		add(HeaderRunStart, h.runStart)
+	}
+	if h.Has(HeaderChecksum) {
+		add(HeaderChecksum, fmt.Sprintf("%08x", crc32.Checksum(payload, crc32c)))
+	}
+	return headers
+}
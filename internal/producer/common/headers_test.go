@@ -0,0 +1,39 @@
+package common
+
+import (
+	"csvreader/internal/models"
+	"testing"
+	"time"
+)
+
+func TestHeaders(t *testing.T) {
+	user := models.User{ID: 1, SourceFile: "users.csv", SourceLine: 7}
+	format := PayloadFormat{ContentType: "application/json", SchemaVersion: "1"}
+
+	// Senza configurazione solo source-file, come prima degli header di provenienza
+	headers := (*Headers)(nil).Build("correlation", &user, []byte("payload"), format)
+	if len(headers) != 2 || headers[0].Key != HeaderCorrelationID || headers[1].Key != HeaderSourceFile {
+		t.Errorf("Attesi correlation-id e source-file, ottenuti: %v", headers)
+	}
+
+	none, err := ParseHeaders("none", time.Now())
+	if err != nil {
+		t.Fatalf("Errore durante la lettura degli header: %v", err)
+	}
+	if headers := none.Build("correlation", &user, []byte("payload"), format); len(headers) != 1 {
+		t.Errorf("Atteso solo correlation-id, ottenuti: %v", headers)
+	}
+
+	// Senza utente (payload già codificati) vengono omessi gli header della riga di input
+	selected, err := ParseHeaders("source-file, source-line,checksum", time.Now())
+	if err != nil {
+		t.Fatalf("Errore durante la lettura degli header: %v", err)
+	}
+	if headers := selected.Build("correlation", nil, []byte("payload"), format); len(headers) != 2 || headers[1].Key != HeaderChecksum {
+		t.Errorf("Attesi correlation-id e checksum, ottenuti: %v", headers)
+	}
+
+	if _, err := ParseHeaders("source-file,timestamp", time.Now()); err == nil {
+		t.Errorf("Atteso errore per un header sconosciuto, ma non si è verificato")
+	}
+}
@@ -0,0 +1,299 @@
+package common
+
+import (
+	"csvreader/internal/checkpoint"
+	"csvreader/internal/models"
+	"csvreader/pkg/logger"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// BuilderFactory crea il MessageBuilder dei messaggi prodotti su 'topic' con le chiavi e gli header configurati.
+// È l'unica parte che cambia da un producer all'altro (vedi i loro NewMessageBuilder).
+type BuilderFactory func(topic string, keying *Keying, headers *Headers) MessageBuilder
+
+// KafkaSink è il Sink che produce su Kafka i messaggi costruiti da una BuilderFactory: produzione, delivery report,
+// retry e dead letter, checkpoint, transazioni, rate limit e statistiche sono comuni a tutti i producer,
+// che lo incorporano e forniscono solo il formato dei messaggi.
+type KafkaSink struct {
+	producer     *kafka.Producer
+	topic        string
+	deliveryChan chan kafka.Event
+	newBuilder   BuilderFactory
+	build        MessageBuilder // payload, chiave e header dei messaggi, ricreato da SetKeying, SetHeaders e SetBuilder
+	keying       *Keying
+	headers      *Headers
+	checkpoint   *checkpoint.Checkpoint
+	counters     Counters      // contatori dei messaggi (vedi Stats)
+	recovery     *Recovery     // retry e dead letter delle consegne fallite, nil = nessun retry
+	limiter      *RateLimiter  // limite di messaggi e byte al secondo, nil = nessun limite
+	deliveries   DeliveryStats // consegne per partizione (vedi PartitionStats)
+
+	// modalità asincrona (vedi NewAsyncKafkaSink)
+	async        bool
+	outstanding  sync.WaitGroup  // messaggi accodati senza ancora un delivery report
+	failures     *DeliveryErrors // consegne fallite dall'ultimo Flush
+	failuresMu   sync.Mutex
+	deliveryDone chan struct{} // chiuso quando la goroutine dei delivery report termina
+
+	// modalità transazionale (vedi NewTransactionalKafkaSink)
+	transactional bool
+	txnOffsets    []int64 // righe consegnate nella transazione in corso
+}
+
+// KafkaSink implementa Sink
+var _ Sink = (*KafkaSink)(nil)
+
+// NewKafkaSink creates a Kafka producer for 'topic' and returns it as a sink producing the messages built by
+// 'newBuilder'. The 'bootstrapServers' parameter is the comma-separated list of Kafka broker addresses.
+// The 'tuning' parameter selects the librdkafka tuning profile and overrides (nil = librdkafka defaults);
+// the effective configuration is logged. ProduceBatch waits for the delivery reports of every batch.
+func NewKafkaSink(bootstrapServers, topic string, tuning *Tuning, newBuilder BuilderFactory) (*KafkaSink, error) {
+	config, err := tuning.ConfigMap(bootstrapServers)
+	if err != nil {
+		return nil, err
+	}
+	p, err := kafka.NewProducer(&config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create producer: %w", err)
+	}
+	LogConfig(config)
+
+	logger.InfoAsync("Kafka producer created successfully")
+
+	return &KafkaSink{
+		producer:     p,
+		topic:        topic,
+		deliveryChan: make(chan kafka.Event, 1000), // buffer to avoid blocking
+		newBuilder:   newBuilder,
+		build:        newBuilder(topic, nil, nil),
+	}, nil
+}
+
+// NewTransactionalKafkaSink creates a sink in transactional mode with 'transactionalID', which should be
+// unique per run (e.g. derived from the correlation ID). Every ProduceBatch call is committed as one Kafka
+// transaction and aborted on any delivery error, so consumers reading with isolation.level=read_committed
+// see either the whole batch or nothing, and a rerun does not duplicate half-written batches.
+func NewTransactionalKafkaSink(bootstrapServers, topic string, tuning *Tuning, transactionalID string, newBuilder BuilderFactory) (*KafkaSink, error) {
+	s, err := NewKafkaSink(bootstrapServers, topic, tuning.WithTransactionalID(transactionalID), newBuilder)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := InitTransactions(s.producer); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	logger.InfoAsync("Transactional producer initialized with transactional.id ", transactionalID)
+	s.transactional = true
+	return s, nil
+}
+
+// Topic returns the topic the messages are produced to.
+func (s *KafkaSink) Topic() string {
+	return s.topic
+}
+
+// Headers returns the provenance headers selected with SetHeaders (nil = source-file only).
+func (s *KafkaSink) Headers() *Headers {
+	return s.headers
+}
+
+// SetBuilder replaces the factory of the message builder, e.g. once the producer knows the schema ID
+// its payloads must be framed with. The keys and headers configured so far are kept.
+func (s *KafkaSink) SetBuilder(newBuilder BuilderFactory) {
+	s.newBuilder = newBuilder
+	s.build = newBuilder(s.topic, s.keying, s.headers)
+}
+
+// SetCheckpoint makes the sink confirm on 'cp' the row offset of every message whose delivery succeeds,
+// possibly after a retry. The rows stored in the dead letter are not confirmed, so the checkpoint stops
+// before the first of them and a resumed run sends it again.
+func (s *KafkaSink) SetCheckpoint(cp *checkpoint.Checkpoint) {
+	s.checkpoint = cp
+}
+
+// SetKeying makes the sink key (and optionally partition) every message according to 'keying',
+// so that all the messages of the same user land in the same partition and keep their order.
+// With a custom partitioner the number of partitions of the topic is read from the cluster.
+func (s *KafkaSink) SetKeying(keying *Keying) error {
+	if err := keying.ResolvePartitions(s.producer, s.topic); err != nil {
+		return err
+	}
+	s.keying = keying
+	s.build = s.newBuilder(s.topic, s.keying, s.headers)
+	return nil
+}
+
+// SetHeaders selects the provenance headers added to every message after the correlation-id.
+func (s *KafkaSink) SetHeaders(headers *Headers) {
+	s.headers = headers
+	s.build = s.newBuilder(s.topic, s.keying, s.headers)
+}
+
+// SetRecovery makes the sink retry the failed deliveries and store the ones that still fail
+// in the dead letter according to 'recovery', instead of failing the batch.
+// It is ignored in transactional mode, where a failed delivery aborts the whole batch.
+func (s *KafkaSink) SetRecovery(recovery *Recovery) {
+	s.recovery = recovery
+}
+
+// SetRateLimiter limits the messages and bytes produced per second according to 'limiter' (nil = no limit),
+// e.g. to stay within the quotas of a shared cluster. The time spent throttled is reported by Stats.
+func (s *KafkaSink) SetRateLimiter(limiter *RateLimiter) {
+	s.limiter = limiter
+}
+
+// Stats returns the counters of the messages produced so far.
+func (s *KafkaSink) Stats() Stats {
+	stats := s.counters.Stats(s.recovery)
+	stats.Throttled = s.limiter.Throttled()
+	return stats
+}
+
+// PartitionStats returns, for every partition, the messages delivered so far with their bytes,
+// first and last offset and delivery latency percentiles.
+func (s *KafkaSink) PartitionStats() []PartitionStats {
+	return s.deliveries.Partitions()
+}
+
+// ProduceBatch builds a message per user, produces the messages in order, and waits for the delivery report
+// of each of them. It returns an error if building, production, or delivery fails. The messages get the key
+// selected with SetKeying and the correlation ID and provenance headers selected with SetHeaders.
+// In async mode (see NewAsyncKafkaSink) it returns as soon as the messages are enqueued:
+// delivery failures are collected in the background and returned by Flush or Close.
+// In transactional mode (see NewTransactionalKafkaSink) the batch is one Kafka transaction,
+// aborted if any message fails, and the checkpoint only advances once the transaction is committed.
+func (s *KafkaSink) ProduceBatch(users []models.User, correlationID string) error {
+	return s.produceInTransaction(len(users), func(i int) (*kafka.Message, error) {
+		return s.build(users[i], correlationID)
+	})
+}
+
+// ProduceMessages produces messages that are already built, e.g. payloads encoded beforehand, in the same
+// way as ProduceBatch. Messages without a row offset in Opaque do not move the checkpoint.
+func (s *KafkaSink) ProduceMessages(messages []*kafka.Message) error {
+	return s.produceInTransaction(len(messages), func(i int) (*kafka.Message, error) {
+		return messages[i], nil
+	})
+}
+
+// produceInTransaction produce gli 'n' messaggi restituiti da 'message', in una transazione se il sink è transazionale
+func (s *KafkaSink) produceInTransaction(n int, message func(i int) (*kafka.Message, error)) error {
+	if !s.transactional {
+		return s.produceBatch(n, message)
+	}
+
+	s.txnOffsets = s.txnOffsets[:0]
+	err := RunTransaction(s.producer, func() error {
+		return s.produceBatch(n, message)
+	})
+	if err != nil {
+		logger.ErrorAsync("Batch transaction failed: ", err)
+		return err
+	}
+	if s.checkpoint != nil {
+		for _, rowOffset := range s.txnOffsets {
+			s.checkpoint.Confirm(rowOffset)
+		}
+	}
+	return nil
+}
+
+func (s *KafkaSink) produceBatch(n int, message func(i int) (*kafka.Message, error)) error {
+	logger.InfoAsync("Starting batch production")
+
+	var produceErr error
+	enqueued := 0
+	for i := 0; i < n; i++ {
+		msg, err := message(i)
+		if err != nil {
+			logger.ErrorAsync("Failed to build message:", err)
+			produceErr = err
+			break
+		}
+
+		s.limiter.Wait(len(msg.Value))
+		err = s.produce(msg)
+		if err != nil {
+			logger.ErrorAsync("Produce failed:", err)
+			produceErr = fmt.Errorf("produce failed: %w", err)
+			break
+		}
+		enqueued++
+		s.counters.AddProduced(len(msg.Value))
+	}
+
+	// In async mode the delivery reports are drained in the background: see Flush
+	if s.async {
+		return produceErr
+	}
+
+	// Wait for the delivery reports of all the enqueued messages, even after a failure,
+	// so that no stale report is left for the next batch
+	if err := WaitForDeliveryReports(s.deliveryChan, enqueued, s.handleDeliveryReport); err != nil && produceErr == nil {
+		produceErr = err
+	}
+	if produceErr != nil {
+		return produceErr
+	}
+	logger.InfoAsync("Batch production completed")
+	return nil
+}
+
+func (s *KafkaSink) handleDeliveryReport(e kafka.Event) error {
+	m := e.(*kafka.Message)
+
+	if m.TopicPartition.Error != nil {
+		logger.ErrorAsync("Delivery failed:", m.TopicPartition.Error)
+		recovery := s.recovery
+		if s.transactional {
+			recovery = nil
+		}
+		redelivered, err := recovery.Recover(s.producer, m)
+		if err != nil {
+			s.counters.AddFailed()
+			return err
+		}
+		if redelivered == nil {
+			return nil // nella dead letter: la riga non è stata consegnata e non fa avanzare il checkpoint
+		}
+		s.deliveries.Record(redelivered, time.Now())
+	} else {
+		s.counters.AddDelivered()
+		s.deliveries.Record(m, time.Now())
+	}
+
+	// Solo le consegne riuscite, anche dopo un retry, fanno avanzare il checkpoint
+	// (in modalità transazionale solo dopo il commit, vedi produceInTransaction)
+	if rowOffset, ok := m.Opaque.(int64); ok && rowOffset > 0 {
+		switch {
+		case s.transactional:
+			s.txnOffsets = append(s.txnOffsets, rowOffset)
+		case s.checkpoint != nil:
+			s.checkpoint.Confirm(rowOffset)
+		}
+	}
+	return nil
+}
+
+// Close flushes the pending messages and closes the producer. In async mode it returns
+// the aggregated delivery errors not yet returned by Flush, nil otherwise.
+func (s *KafkaSink) Close() error {
+	var err error
+	if s.async {
+		err = s.Flush()
+	}
+
+	logger.InfoAsync("Closing producer")
+	close(s.deliveryChan)
+	if s.async {
+		<-s.deliveryDone
+	}
+	s.producer.Close()
+	return err
+}
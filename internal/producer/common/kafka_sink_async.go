@@ -0,0 +1,127 @@
+package common
+
+import (
+	"csvreader/pkg/logger"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// DeliveryErrors aggrega le consegne fallite in modalità asincrona, raggruppate per errore
+type DeliveryErrors struct {
+	Failed int            // numero totale di messaggi non consegnati
+	ByErr  map[string]int // messaggi non consegnati per ciascun errore
+}
+
+func (e *DeliveryErrors) Error() string {
+	reasons := make([]string, 0, len(e.ByErr))
+	for reason, count := range e.ByErr {
+		reasons = append(reasons, fmt.Sprintf("%s (%d)", reason, count))
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("delivery failed for %d messages: %s", e.Failed, strings.Join(reasons, ", "))
+}
+
+// NewAsyncKafkaSink creates a sink in fully asynchronous mode: ProduceBatch only enqueues the messages,
+// while a background goroutine continuously drains the delivery reports and tracks the outstanding messages.
+// This way librdkafka's queue never stalls at batch boundaries. Delivery failures do not stop the production:
+// they are aggregated and returned by Flush or Close.
+func NewAsyncKafkaSink(bootstrapServers, topic string, tuning *Tuning, newBuilder BuilderFactory) (*KafkaSink, error) {
+	s, err := NewKafkaSink(bootstrapServers, topic, tuning, newBuilder)
+	if err != nil {
+		return nil, err
+	}
+
+	s.async = true
+	s.deliveryDone = make(chan struct{})
+	go s.deliveryLoop()
+	return s, nil
+}
+
+// deliveryLoop legge i delivery report finché il canale non viene chiuso da Close
+func (s *KafkaSink) deliveryLoop() {
+	defer close(s.deliveryDone)
+	recoveries := NewRecoveryPool()
+	defer recoveries.Wait()
+	for e := range s.deliveryChan {
+		// I retry attendono il backoff: le consegne fallite sono gestite a parte per non fermare il loop
+		if Failed(e) {
+			recoveries.Go(func() { s.handleAsyncDeliveryReport(e) })
+			continue
+		}
+		s.handleAsyncDeliveryReport(e)
+	}
+}
+
+func (s *KafkaSink) handleAsyncDeliveryReport(e kafka.Event) {
+	defer s.outstanding.Done()
+	if err := s.handleDeliveryReport(e); err != nil {
+		s.recordFailure(err)
+	}
+}
+
+func (s *KafkaSink) recordFailure(err error) {
+	s.failuresMu.Lock()
+	defer s.failuresMu.Unlock()
+
+	if s.failures == nil {
+		s.failures = &DeliveryErrors{ByErr: make(map[string]int)}
+	}
+	s.failures.Failed++
+	if cause := errors.Unwrap(err); cause != nil {
+		err = cause
+	}
+	s.failures.ByErr[err.Error()]++
+}
+
+// produce accoda il messaggio. In modalità asincrona lo conta tra quelli in attesa di delivery report
+// e, se la coda locale di librdkafka è piena, attende che si liberi invece di fallire.
+func (s *KafkaSink) produce(msg *kafka.Message) error {
+	if !s.async {
+		return s.producer.Produce(msg, s.deliveryChan)
+	}
+
+	s.outstanding.Add(1)
+	for {
+		err := s.producer.Produce(msg, s.deliveryChan)
+		if err == nil {
+			return nil
+		}
+
+		var kafkaErr kafka.Error
+		if errors.As(err, &kafkaErr) && kafkaErr.Code() == kafka.ErrQueueFull {
+			time.Sleep(queueFullBackoff)
+			continue
+		}
+		s.outstanding.Done()
+		return err
+	}
+}
+
+// queueFullBackoff è l'attesa prima di riprovare quando la coda locale di librdkafka è piena
+const queueFullBackoff = 10 * time.Millisecond
+
+// Flush waits until every enqueued message has a delivery report and returns the aggregated
+// delivery errors collected since the previous Flush, or nil if every message was delivered.
+// It is a no-op in synchronous mode, where ProduceBatch already waits for the reports.
+func (s *KafkaSink) Flush() error {
+	if !s.async {
+		return nil
+	}
+
+	logger.InfoAsync("Flushing producer")
+	s.outstanding.Wait()
+
+	s.failuresMu.Lock()
+	defer s.failuresMu.Unlock()
+	if s.failures == nil {
+		return nil
+	}
+	err := s.failures
+	s.failures = nil
+	return err
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"csvreader/internal/checkpoint"
+	"csvreader/internal/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func TestKafkaSink(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del mock cluster: %v", err)
+	}
+	defer cluster.Close()
+	if err := cluster.CreateTopic("users", 1, 1); err != nil {
+		t.Fatalf("Errore durante la creazione del topic: %v", err)
+	}
+
+	// Il sink produce i messaggi costruiti dalla BuilderFactory
+	format := PayloadFormat{ContentType: "text/plain", SchemaVersion: "1"}
+	newBuilder := func(prefix string) BuilderFactory {
+		return func(topic string, keying *Keying, headers *Headers) MessageBuilder {
+			return func(user models.User, correlationID string) (*kafka.Message, error) {
+				payload := []byte(prefix + user.NomeUtente)
+				key, partition := keying.Route(user)
+				return &kafka.Message{
+					TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition},
+					Key:            key,
+					Value:          payload,
+					Headers:        headers.Build(correlationID, &user, payload, format),
+					Opaque:         user.RowOffset,
+				}, nil
+			}
+		}
+	}
+	sink, err := NewKafkaSink(cluster.BootstrapServers(), "users", nil, newBuilder("v1:"))
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del sink: %v", err)
+	}
+	defer sink.Close()
+	cp := checkpoint.New(filepath.Join(t.TempDir(), "checkpoint.json"), "users.csv", 0)
+	sink.SetCheckpoint(cp)
+
+	if err := sink.ProduceBatch([]models.User{{ID: 1, NomeUtente: "mario", RowOffset: 1}}, "correlation"); err != nil {
+		t.Fatalf("Errore durante la produzione del batch: %v", err)
+	}
+	// SetBuilder cambia il formato dei messaggi successivi
+	sink.SetBuilder(newBuilder("v2:"))
+	if err := sink.ProduceBatch([]models.User{{ID: 2, NomeUtente: "luigi", RowOffset: 2}}, "correlation"); err != nil {
+		t.Fatalf("Errore durante la produzione del batch: %v", err)
+	}
+	// I messaggi senza riga in Opaque non fanno avanzare il checkpoint
+	topic := "users"
+	raw := &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny}, Value: []byte("raw")}
+	if err := sink.ProduceMessages([]*kafka.Message{raw}); err != nil {
+		t.Fatalf("Errore durante la produzione dei messaggi: %v", err)
+	}
+
+	if cp.Offset() != 2 {
+		t.Errorf("Offset del checkpoint atteso: 2, ottenuto: %d", cp.Offset())
+	}
+	if stats := sink.Stats(); stats.Produced != 3 || stats.Delivered != 3 {
+		t.Errorf("Statistiche inattese: %v", stats)
+	}
+	if partitions := sink.PartitionStats(); len(partitions) != 1 || partitions[0].Messages != 3 {
+		t.Errorf("Statistiche per partizione inattese: %v", partitions)
+	}
+
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": cluster.BootstrapServers(),
+		"group.id":          "test",
+		"auto.offset.reset": "earliest",
+	})
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del consumer: %v", err)
+	}
+	defer consumer.Close()
+	if err := consumer.Subscribe("users", nil); err != nil {
+		t.Fatalf("Errore durante la sottoscrizione al topic: %v", err)
+	}
+	for _, expected := range []string{"v1:mario", "v2:luigi", "raw"} {
+		msg, err := consumer.ReadMessage(10 * time.Second)
+		if err != nil {
+			t.Fatalf("Errore durante la lettura del messaggio %q: %v", expected, err)
+		}
+		if string(msg.Value) != expected {
+			t.Errorf("Messaggio atteso: %q, ottenuto: %q", expected, msg.Value)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package common
+
+import (
+	"crypto/sha256"
+	"csvreader/internal/models"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Strategie di chiave dei messaggi
+const (
+	KeyNone      = "none"       // nessuna chiave: i messaggi vengono distribuiti su tutte le partizioni
+	KeyID        = "id"         // User.ID in forma decimale
+	KeyEmailHash = "email-hash" // SHA-256 esadecimale dell'email, per non esporre l'indirizzo nella chiave
+	KeyField     = "field:"     // prefisso per usare come chiave un campo qualsiasi di User, es. field:NomeUtente
+)
+
+// Partitioner sceglie la partizione di un messaggio a partire dalla chiave e dall'utente.
+// 'partitions' è il numero di partizioni del topic, il risultato deve essere in [0, partitions).
+type Partitioner func(key []byte, user models.User, partitions int32) int32
+
+// idModulo è l'unico partitioner che non calcola la partizione dalla chiave, quindi l'unico valido senza chiave
+const idModulo = "id-modulo"
+
+// Partitioner disponibili per nome. Senza partitioner la partizione viene scelta da librdkafka
+// (consistent_random: stessa chiave, stessa partizione).
+var partitioners = map[string]Partitioner{
+	// murmur2 è lo stesso algoritmo del client Java, utile se altri producer Java scrivono sullo stesso topic
+	"murmur2": func(key []byte, _ models.User, partitions int32) int32 {
+		return int32(murmur2(key)&0x7fffffff) % partitions
+	},
+	"fnv1a": func(key []byte, _ models.User, partitions int32) int32 {
+		h := fnv.New32a()
+		h.Write(key)
+		return int32(h.Sum32() % uint32(partitions))
+	},
+	// id-modulo mette l'utente N nella partizione N % partizioni, indipendentemente dalla chiave
+	idModulo: func(_ []byte, user models.User, partitions int32) int32 {
+		partition := int32(user.ID % int(partitions))
+		if partition < 0 {
+			partition += partitions
+		}
+		return partition
+	},
+}
+
+// Keying decide la chiave e la partizione dei messaggi prodotti per ogni utente,
+// così i messaggi dello stesso utente finiscono sempre nella stessa partizione e restano ordinati.
+// Il valore nil non imposta chiavi e lascia la scelta della partizione a librdkafka.
+type Keying struct {
+	strategy    string
+	field       string
+	partitioner Partitioner
+	partitions  int32
+}
+
+// NewKeying parses the key strategy ("none", "id", "email-hash" or "field:<User field>") and the optional
+// partitioner name ("" for librdkafka's default, "murmur2", "fnv1a" or "id-modulo").
+// It returns nil when neither keys nor a partitioner are configured, and an error for a partitioner hashing
+// the key without a key strategy, which would send every message to the same partition.
+func NewKeying(strategy, partitioner string) (*Keying, error) {
+	k := &Keying{strategy: strings.TrimSpace(strategy)}
+
+	switch {
+	case k.strategy == "" || k.strategy == KeyNone:
+		k.strategy = KeyNone
+	case k.strategy == KeyID || k.strategy == KeyEmailHash:
+	case strings.HasPrefix(k.strategy, KeyField):
+		k.field = strings.TrimPrefix(k.strategy, KeyField)
+		if _, err := userField(models.User{}, k.field); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown key strategy %q: use none, id, email-hash or field:<User field>", strategy)
+	}
+
+	if partitioner != "" {
+		p, ok := partitioners[partitioner]
+		if !ok {
+			return nil, fmt.Errorf("unknown partitioner %q: use murmur2, fnv1a or id-modulo", partitioner)
+		}
+		if k.strategy == KeyNone && partitioner != idModulo {
+			return nil, fmt.Errorf("partitioner %q hashes the message key: it needs a key strategy other than none", partitioner)
+		}
+		k.partitioner = p
+	}
+
+	if k.strategy == KeyNone && k.partitioner == nil {
+		return nil, nil
+	}
+	return k, nil
+}
+
+// Partitioned reports whether the partition of the messages is chosen by a custom partitioner
+// instead of librdkafka, so that a retried message must go back to the same partition.
+func (k *Keying) Partitioned() bool {
+	return k != nil && k.partitioner != nil
+}
+
+// ResolvePartitions reads the number of partitions of 'topic' from the cluster metadata.
+// It is only needed, and only called, when a partitioner is configured.
+func (k *Keying) ResolvePartitions(producer *kafka.Producer, topic string) error {
+	if k == nil || k.partitioner == nil {
+		return nil
+	}
+
+	metadata, err := producer.GetMetadata(&topic, false, metadataTimeoutMs)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata of topic %s: %w", topic, err)
+	}
+	topicMetadata, ok := metadata.Topics[topic]
+	if !ok || topicMetadata.Error.Code() != kafka.ErrNoError || len(topicMetadata.Partitions) == 0 {
+		return fmt.Errorf("topic %s has no partitions available: %v", topic, topicMetadata.Error)
+	}
+	k.partitions = int32(len(topicMetadata.Partitions))
+	return nil
+}
+
+// metadataTimeoutMs è il tempo massimo di attesa dei metadati del topic
+const metadataTimeoutMs = 10000
+
+// Route returns the key of the user's message and its partition (kafka.PartitionAny
+// when the partition is left to librdkafka).
+func (k *Keying) Route(user models.User) ([]byte, int32) {
+	if k == nil {
+		return nil, kafka.PartitionAny
+	}
+
+	var key []byte
+	switch k.strategy {
+	case KeyID:
+		key = strconv.AppendInt(nil, int64(user.ID), 10)
+	case KeyEmailHash:
+		sum := sha256.Sum256([]byte(user.Email))
+		key = []byte(hex.EncodeToString(sum[:]))
+	case KeyNone:
+	default:
+		value, _ := userField(user, k.field) // il campo è stato verificato da NewKeying
+		key = []byte(value)
+	}
+
+	if k.partitioner == nil || k.partitions <= 0 {
+		return key, kafka.PartitionAny
+	}
+	return key, k.partitioner(key, user, k.partitions)
+}
+
+func userField(user models.User, field string) (string, error) {
+	switch field {
+	case "ID":
+		return strconv.Itoa(user.ID), nil
+	case "NomeUtente":
+		return user.NomeUtente, nil
+	case "Email":
+		return user.Email, nil
+	default:
+		return "", fmt.Errorf("unknown User field %q for the message key: use ID, NomeUtente or Email", field)
+	}
+}
+
+// murmur2 è la variante di MurmurHash2 usata dal partitioner di default del client Java di Kafka
+func murmur2(data []byte) uint32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"csvreader/internal/models"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func TestKeying(t *testing.T) {
+	user := models.User{ID: 42, NomeUtente: "mario", Email: "mario@example.com"}
+
+	keying, err := NewKeying(KeyNone, "")
+	if err != nil || keying != nil {
+		t.Fatalf("Atteso keying nil senza chiave né partitioner, ottenuto: %v, %v", keying, err)
+	}
+	if key, partition := keying.Route(user); key != nil || partition != kafka.PartitionAny {
+		t.Errorf("Attesi nessuna chiave e PartitionAny, ottenuti: %q, %d", key, partition)
+	}
+
+	cases := map[string]string{
+		KeyID:                   "42",
+		KeyField + "Email":      "mario@example.com",
+		KeyEmailHash:            "94975275c0782c9df03b43f60e2da7f45d8fe447c73d4c52fad895d2937ae9e3",
+		KeyField + "NomeUtente": "mario",
+	}
+	for strategy, expected := range cases {
+		keying, err := NewKeying(strategy, "")
+		if err != nil {
+			t.Fatalf("Errore durante la creazione del keying %s: %v", strategy, err)
+		}
+		key, partition := keying.Route(user)
+		if string(key) != expected {
+			t.Errorf("Chiave %s attesa: %q, ottenuta: %q", strategy, expected, key)
+		}
+		if partition != kafka.PartitionAny {
+			t.Errorf("Chiave %s: attesa PartitionAny senza partitioner, ottenuta: %d", strategy, partition)
+		}
+	}
+
+	for _, invalid := range [][2]string{{"name", ""}, {KeyField + "Telefono", ""}, {KeyID, "round-robin"}, {KeyNone, "murmur2"}, {"", "fnv1a"}} {
+		if _, err := NewKeying(invalid[0], invalid[1]); err == nil {
+			t.Errorf("Atteso errore per %v, ma non si è verificato", invalid)
+		}
+	}
+
+	// Con un partitioner la partizione è calcolata sul numero di partizioni del topic
+	keying, err = NewKeying(KeyID, "id-modulo")
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del keying: %v", err)
+	}
+	keying.partitions = 4
+	if _, partition := keying.Route(user); partition != 2 {
+		t.Errorf("Partizione attesa: 2, ottenuta: %d", partition)
+	}
+}
+
+func TestMurmur2(t *testing.T) {
+	// Valori di riferimento del client Java di Kafka (Utils.murmur2)
+	cases := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for data, expected := range cases {
+		if hash := int32(murmur2([]byte(data))); hash != expected {
+			t.Errorf("murmur2(%q) atteso: %d, ottenuto: %d", data, expected, hash)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"csvreader/internal/checkpoint"
+	"csvreader/internal/models"
+	"errors"
+	"sync"
+)
+
+// MultiSink inoltra ogni batch a più Sink, per esempio ai producer JSON e Avro che scrivono su topic diversi
+type MultiSink struct {
+	sinks []Sink
+}
+
+// MultiSink implementa Sink
+var _ Sink = (*MultiSink)(nil)
+
+// NewMultiSink returns a sink producing every batch to all the 'sinks'.
+func NewMultiSink(sinks ...Sink) *MultiSink {
+	return &MultiSink{sinks: sinks}
+}
+
+// SetCheckpoint passes 'cp' to the sinks that confirm their deliveries on a checkpoint: a row only counts
+// as delivered once every sink has confirmed it, so that a resume sends it again to the sinks that missed it.
+func (m *MultiSink) SetCheckpoint(cp *checkpoint.Checkpoint) {
+	cp.RequireConfirmations(len(m.sinks))
+	for _, sink := range m.sinks {
+		if s, ok := sink.(interface{ SetCheckpoint(*checkpoint.Checkpoint) }); ok {
+			s.SetCheckpoint(cp)
+		}
+	}
+}
+
+// ProduceBatch produces the batch to all the sinks in parallel and returns their errors joined.
+func (m *MultiSink) ProduceBatch(users []models.User, correlationID string) error {
+	errs := make([]error, len(m.sinks))
+	var wg sync.WaitGroup
+	for i, sink := range m.sinks {
+		wg.Add(1)
+		go func(i int, sink Sink) {
+			defer wg.Done()
+			errs[i] = sink.ProduceBatch(users, correlationID)
+		}(i, sink)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Flush flushes all the sinks and returns their errors joined.
+func (m *MultiSink) Flush() error {
+	var errs []error
+	for _, sink := range m.sinks {
+		errs = append(errs, sink.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes all the sinks and returns their errors joined.
+func (m *MultiSink) Close() error {
+	var errs []error
+	for _, sink := range m.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Stats returns the sum of the counters of all the sinks.
+func (m *MultiSink) Stats() Stats {
+	var total Stats
+	for _, sink := range m.sinks {
+		s := sink.Stats()
+		total.Produced += s.Produced
+		total.Bytes += s.Bytes
+		total.Delivered += s.Delivered
+		total.Failed += s.Failed
+		total.DeadLettered += s.DeadLettered
+		total.Throttled += s.Throttled
+	}
+	return total
+}
@@ -0,0 +1,168 @@
+package common
+
+import (
+	"cmp"
+	"fmt"
+	"math/bits"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// PartitionStats riassume le consegne confermate su una partizione
+type PartitionStats struct {
+	Topic       string
+	Partition   int32
+	Messages    int64
+	Bytes       int64 // byte dei payload
+	FirstOffset kafka.Offset
+	LastOffset  kafka.Offset
+	// percentili della latenza di consegna, dal timestamp del messaggio al delivery report
+	P50, P95, P99 time.Duration
+}
+
+func (s PartitionStats) String() string {
+	return fmt.Sprintf("%s [%d]: %d messages (%d bytes), offsets %v-%v, latency p50 %s p95 %s p99 %s",
+		s.Topic, s.Partition, s.Messages, s.Bytes, s.FirstOffset, s.LastOffset, s.P50, s.P95, s.P99)
+}
+
+// DeliveryStats aggrega i delivery report riusciti per partizione. Lo zero value è pronto all'uso
+// ed è sicuro per l'uso concorrente.
+type DeliveryStats struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionDeliveries
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type partitionDeliveries struct {
+	messages, bytes         int64
+	firstOffset, lastOffset kafka.Offset
+	latencies               latencyHistogram
+}
+
+// Bucket dell'istogramma delle latenze: i millisecondi sotto latencySubBuckets hanno un bucket ciascuno,
+// ogni raddoppio successivo è diviso in latencySubBuckets bucket di uguale ampiezza, fino a 2^(latencyMaxBits+1) ms
+// (oltre due ore, le latenze più alte finiscono nell'ultimo bucket)
+const (
+	latencySubBits    = 6
+	latencySubBuckets = 1 << latencySubBits
+	latencyMaxBits    = 22
+	latencyBuckets    = (latencyMaxBits - latencySubBits + 2) * latencySubBuckets
+)
+
+// latencyHistogram conta le latenze in bucket fissi, così la memoria non cresce con i messaggi consegnati.
+// I percentili hanno un errore relativo massimo di 1/latencySubBuckets (1,6%), esatti sotto i 128ms.
+type latencyHistogram struct {
+	counts [latencyBuckets]int64
+	total  int64
+}
+
+// add conta una latenza, con la risoluzione al millisecondo dei timestamp dei messaggi
+func (h *latencyHistogram) add(latency time.Duration) {
+	h.counts[latencyBucket(max(latency.Milliseconds(), 0))]++
+	h.total++
+}
+
+// percentile restituisce il p-esimo percentile (nearest rank): il valore più alto del bucket che lo contiene
+func (h *latencyHistogram) percentile(p int) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := max((int64(p)*h.total+99)/100, 1) // ceil(p/100 * n)
+	var seen int64
+	for bucket, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			return time.Duration(latencyBucketMax(bucket)) * time.Millisecond
+		}
+	}
+	return time.Duration(latencyBucketMax(latencyBuckets-1)) * time.Millisecond
+}
+
+// latencyBucket restituisce il bucket dei millisecondi 'ms'
+func latencyBucket(ms int64) int {
+	if ms < latencySubBuckets {
+		return int(ms)
+	}
+	exp := bits.Len64(uint64(ms)) - 1 // ms è in [2^exp, 2^(exp+1))
+	if exp > latencyMaxBits {
+		return latencyBuckets - 1
+	}
+	sub := int(ms>>(exp-latencySubBits)) - latencySubBuckets
+	return (exp-latencySubBits+1)*latencySubBuckets + sub
+}
+
+// latencyBucketMax restituisce il valore più alto, in millisecondi, del bucket
+func latencyBucketMax(bucket int) int64 {
+	if bucket < latencySubBuckets {
+		return int64(bucket)
+	}
+	exp := bucket/latencySubBuckets + latencySubBits - 1
+	sub := int64(bucket % latencySubBuckets)
+	width := int64(1) << (exp - latencySubBits)
+	return (latencySubBuckets+sub)*width + width - 1
+}
+
+// Record adds the successful delivery report 'm' received at 'now'. The latency is measured from the
+// message timestamp, which librdkafka sets when the message is produced (millisecond resolution).
+func (d *DeliveryStats) Record(m *kafka.Message, now time.Time) {
+	key := topicPartition{partition: m.TopicPartition.Partition}
+	if m.TopicPartition.Topic != nil {
+		key.topic = *m.TopicPartition.Topic
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.partitions == nil {
+		d.partitions = make(map[topicPartition]*partitionDeliveries)
+	}
+	p, ok := d.partitions[key]
+	if !ok {
+		p = &partitionDeliveries{firstOffset: m.TopicPartition.Offset, lastOffset: m.TopicPartition.Offset}
+		d.partitions[key] = p
+	}
+
+	p.messages++
+	p.bytes += int64(len(m.Value))
+	p.firstOffset = min(p.firstOffset, m.TopicPartition.Offset)
+	p.lastOffset = max(p.lastOffset, m.TopicPartition.Offset)
+	if !m.Timestamp.IsZero() {
+		p.latencies.add(now.Sub(m.Timestamp))
+	}
+}
+
+// Partitions returns the stats of every partition that received at least one message, sorted by topic and partition.
+func (d *DeliveryStats) Partitions() []PartitionStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := make([]PartitionStats, 0, len(d.partitions))
+	for key, p := range d.partitions {
+		stats = append(stats, PartitionStats{
+			Topic:       key.topic,
+			Partition:   key.partition,
+			Messages:    p.messages,
+			Bytes:       p.bytes,
+			FirstOffset: p.firstOffset,
+			LastOffset:  p.lastOffset,
+			P50:         p.latencies.percentile(50),
+			P95:         p.latencies.percentile(95),
+			P99:         p.latencies.percentile(99),
+		})
+	}
+
+	slices.SortFunc(stats, func(a, b PartitionStats) int {
+		if c := strings.Compare(a.Topic, b.Topic); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Partition, b.Partition)
+	})
+	return stats
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func TestDeliveryStats(t *testing.T) {
+	var stats DeliveryStats
+	if len(stats.Partitions()) != 0 {
+		t.Fatal("Attese nessuna partizione senza consegne")
+	}
+
+	topic := "users"
+	sent := time.Unix(100, 0)
+	// 100 consegne sulla partizione 1 con latenze da 1 a 100ms, una sulla partizione 0
+	for i := 1; i <= 100; i++ {
+		stats.Record(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 1, Offset: kafka.Offset(10 + i)},
+			Value:          []byte("abc"),
+			Timestamp:      sent,
+		}, sent.Add(time.Duration(i)*time.Millisecond))
+	}
+	stats.Record(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: 0},
+		Value:          []byte("abcdef"),
+		Timestamp:      sent,
+	}, sent.Add(5*time.Millisecond))
+
+	partitions := stats.Partitions()
+	if len(partitions) != 2 || partitions[0].Partition != 0 || partitions[1].Partition != 1 {
+		t.Fatalf("Attese le partizioni 0 e 1 in ordine, ottenute: %v", partitions)
+	}
+	if p := partitions[0]; p.Topic != "users" || p.Messages != 1 || p.Bytes != 6 || p.P50 != 5*time.Millisecond || p.P99 != 5*time.Millisecond {
+		t.Errorf("Statistiche della partizione 0 inattese: %v", p)
+	}
+	p := partitions[1]
+	if p.Messages != 100 || p.Bytes != 300 || p.FirstOffset != 11 || p.LastOffset != 110 {
+		t.Errorf("Statistiche della partizione 1 inattese: %v", p)
+	}
+	if p.P50 != 50*time.Millisecond || p.P95 != 95*time.Millisecond || p.P99 != 99*time.Millisecond {
+		t.Errorf("Percentili attesi 50ms, 95ms e 99ms, ottenuti: %s, %s, %s", p.P50, p.P95, p.P99)
+	}
+
+	// Le latenze alte finiscono in bucket più ampi: il percentile resta entro l'1,6% del valore esatto
+	var slow DeliveryStats
+	for i := 1; i <= 1000; i++ {
+		slow.Record(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}, Timestamp: sent}, sent.Add(time.Duration(10000+i)*time.Millisecond))
+	}
+	if p50 := slow.Partitions()[0].P50; p50 < 10500*time.Millisecond || p50 > 10668*time.Millisecond {
+		t.Errorf("Percentile 50 atteso circa 10.5s, ottenuto: %s", p50)
+	}
+}
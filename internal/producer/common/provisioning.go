@@ -0,0 +1,130 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"csvreader/pkg/logger"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// provisioningTimeout è il tempo massimo concesso a ogni richiesta all'AdminClient
+const provisioningTimeout = 30 * time.Second
+
+// TopicSpec descrive come deve essere configurato un topic
+type TopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	Configs           map[string]string // es. retention.ms, cleanup.policy
+}
+
+// EnsureTopic creates the topic described by 'spec' with the AdminClient if it does not exist,
+// otherwise verifies that its partitions, replication factor and configs match the spec.
+// On mismatch it returns an error listing every difference, so that the run stops before producing.
+func EnsureTopic(bootstrapServers string, spec TopicSpec) error {
+	admin, err := kafka.NewAdminClient(&kafka.ConfigMap{"bootstrap.servers": bootstrapServers})
+	if err != nil {
+		return fmt.Errorf("failed to create admin client: %w", err)
+	}
+	defer admin.Close()
+
+	// Metadati di tutti i topic: richiedere il singolo topic può crearlo con le impostazioni di default del broker
+	metadata, err := admin.GetMetadata(nil, true, int(provisioningTimeout.Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("failed to read metadata of topic %s: %w", spec.Name, err)
+	}
+	topic, ok := metadata.Topics[spec.Name]
+	if !ok || topic.Error.Code() == kafka.ErrUnknownTopicOrPart || topic.Error.Code() == kafka.ErrUnknownTopic {
+		return createTopic(admin, spec)
+	}
+	if topic.Error.Code() != kafka.ErrNoError {
+		return fmt.Errorf("failed to read metadata of topic %s: %v", spec.Name, topic.Error)
+	}
+	return verifyTopic(admin, spec, topic)
+}
+
+func createTopic(admin *kafka.AdminClient, spec TopicSpec) error {
+	ctx, cancel := context.WithTimeout(context.Background(), provisioningTimeout)
+	defer cancel()
+
+	results, err := admin.CreateTopics(ctx, []kafka.TopicSpecification{{
+		Topic:             spec.Name,
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+		Config:            spec.Configs,
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to create topic %s: %w", spec.Name, err)
+	}
+	for _, result := range results {
+		if result.Error.Code() != kafka.ErrNoError {
+			return fmt.Errorf("failed to create topic %s: %v", spec.Name, result.Error)
+		}
+	}
+
+	logger.InfoAsync("Topic ", spec.Name, " created with ", spec.Partitions, " partitions, replication factor ",
+		spec.ReplicationFactor, ", configs ", spec.Configs)
+	return nil
+}
+
+// verifyTopic confronta il topic esistente con la specifica e restituisce tutte le differenze trovate
+func verifyTopic(admin *kafka.AdminClient, spec TopicSpec, topic kafka.TopicMetadata) error {
+	var mismatches []string
+	if len(topic.Partitions) != spec.Partitions {
+		mismatches = append(mismatches, fmt.Sprintf("partitions: %d, expected %d", len(topic.Partitions), spec.Partitions))
+	}
+	if len(topic.Partitions) > 0 && len(topic.Partitions[0].Replicas) != spec.ReplicationFactor {
+		mismatches = append(mismatches, fmt.Sprintf("replication factor: %d, expected %d",
+			len(topic.Partitions[0].Replicas), spec.ReplicationFactor))
+	}
+
+	if len(spec.Configs) > 0 {
+		actual, err := describeTopicConfigs(admin, spec.Name)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(spec.Configs))
+		for name := range spec.Configs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if value, ok := actual[name]; !ok || value != spec.Configs[name] {
+				mismatches = append(mismatches, fmt.Sprintf("%s: %q, expected %q", name, value, spec.Configs[name]))
+			}
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("topic %s exists but does not match the configuration (%s): fix the topic or the flags",
+			spec.Name, strings.Join(mismatches, "; "))
+	}
+	logger.InfoAsync("Topic ", spec.Name, " matches the configuration")
+	return nil
+}
+
+func describeTopicConfigs(admin *kafka.AdminClient, name string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), provisioningTimeout)
+	defer cancel()
+
+	results, err := admin.DescribeConfigs(ctx, []kafka.ConfigResource{{Type: kafka.ResourceTopic, Name: name}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe configs of topic %s: %w", name, err)
+	}
+
+	configs := make(map[string]string)
+	for _, result := range results {
+		if result.Error.Code() != kafka.ErrNoError {
+			return nil, fmt.Errorf("failed to describe configs of topic %s: %v", name, result.Error)
+		}
+		for configName, entry := range result.Config {
+			configs[configName] = entry.Value
+		}
+	}
+	return configs, nil
+}
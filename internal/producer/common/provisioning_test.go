@@ -0,0 +1,37 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func TestEnsureTopic(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del mock cluster: %v", err)
+	}
+	defer cluster.Close()
+
+	// Il mock cluster non gestisce CreateTopics: il topic viene creato direttamente e EnsureTopic lo verifica
+	if err := cluster.CreateTopic("users", 3, 1); err != nil {
+		t.Fatalf("Errore durante la creazione del topic: %v", err)
+	}
+	spec := TopicSpec{Name: "users", Partitions: 3, ReplicationFactor: 1}
+	if err := EnsureTopic(cluster.BootstrapServers(), spec); err != nil {
+		t.Errorf("Errore inatteso nella verifica del topic: %v", err)
+	}
+
+	spec.Partitions = 6
+	err = EnsureTopic(cluster.BootstrapServers(), spec)
+	if err == nil || !strings.Contains(err.Error(), "partitions: 3, expected 6") {
+		t.Errorf("Atteso errore per il numero di partizioni diverso, ottenuto: %v", err)
+	}
+
+	spec = TopicSpec{Name: "users", Partitions: 3, ReplicationFactor: 3}
+	err = EnsureTopic(cluster.BootstrapServers(), spec)
+	if err == nil || !strings.Contains(err.Error(), "replication factor: 1, expected 3") {
+		t.Errorf("Atteso errore per il replication factor diverso, ottenuto: %v", err)
+	}
+}
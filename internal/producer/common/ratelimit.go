@@ -0,0 +1,86 @@
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimiter limita i messaggi e i byte prodotti al secondo con due token bucket, uno per limite.
+// Ogni bucket si riempie alla velocità configurata fino a un secondo di burst; una richiesta che supera
+// i token disponibili li prenota comunque (il bucket va in negativo) e attende il tempo necessario a ripagarli,
+// così anche un messaggio più grande del burst passa e le richieste concorrenti vengono servite in ordine.
+type RateLimiter struct {
+	mu        sync.Mutex
+	messages  *tokenBucket // nil = nessun limite sui messaggi
+	bytes     *tokenBucket // nil = nessun limite sui byte
+	throttled atomic.Int64 // nanosecondi di attesa accumulati
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+type tokenBucket struct {
+	rate   float64 // token al secondo, anche dimensione del burst
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter of 'messagesPerSec' messages and 'bytesPerSec' payload bytes per second,
+// 0 meaning no limit. It returns nil, which never waits, when neither limit is set.
+func NewRateLimiter(messagesPerSec, bytesPerSec float64) *RateLimiter {
+	if messagesPerSec <= 0 && bytesPerSec <= 0 {
+		return nil
+	}
+
+	l := &RateLimiter{now: time.Now, sleep: time.Sleep}
+	start := l.now()
+	if messagesPerSec > 0 {
+		l.messages = &tokenBucket{rate: messagesPerSec, tokens: messagesPerSec, last: start}
+	}
+	if bytesPerSec > 0 {
+		l.bytes = &tokenBucket{rate: bytesPerSec, tokens: bytesPerSec, last: start}
+	}
+	return l
+}
+
+// Wait blocks until a message with a payload of 'size' bytes can be produced within the limits,
+// and adds the time spent waiting to Throttled. A nil limiter returns immediately.
+func (l *RateLimiter) Wait(size int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	wait := max(l.messages.take(1, now), l.bytes.take(float64(size), now))
+	l.mu.Unlock()
+
+	if wait > 0 {
+		l.throttled.Add(int64(wait))
+		l.sleep(wait)
+	}
+}
+
+// Throttled returns the total time spent waiting in Wait. A nil limiter returns 0.
+func (l *RateLimiter) Throttled() time.Duration {
+	if l == nil {
+		return 0
+	}
+	return time.Duration(l.throttled.Load())
+}
+
+// take preleva n token e restituisce quanto attendere perché il bucket torni in pari
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
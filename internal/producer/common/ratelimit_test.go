@@ -0,0 +1,45 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if NewRateLimiter(0, 0) != nil {
+		t.Fatal("Atteso limiter nil senza limiti")
+	}
+	var none *RateLimiter
+	none.Wait(100)
+	if none.Throttled() != 0 {
+		t.Errorf("Atteso nessuna attesa con limiter nil, ottenuto: %v", none.Throttled())
+	}
+
+	// Orologio finto: sleep fa avanzare il tempo senza attendere davvero
+	clock := time.Unix(0, 0)
+	limiter := NewRateLimiter(10, 1000)
+	limiter.now = func() time.Time { return clock }
+	limiter.sleep = func(d time.Duration) { clock = clock.Add(d) }
+	limiter.messages.last, limiter.bytes.last = clock, clock
+
+	// Il burst iniziale di 10 messaggi da 50 byte passa senza attese
+	for i := 0; i < 10; i++ {
+		limiter.Wait(50)
+	}
+	if limiter.Throttled() != 0 {
+		t.Fatalf("Atteso nessuna attesa entro il burst, ottenuto: %v", limiter.Throttled())
+	}
+
+	// Oltre il burst ogni messaggio attende 1/10 di secondo
+	limiter.Wait(50)
+	if limiter.Throttled() != 100*time.Millisecond {
+		t.Errorf("Attesa di 100ms, ottenuta: %v", limiter.Throttled())
+	}
+
+	// Un messaggio di 1500 byte, più grande del burst, è limitato dai byte: ne restano 550
+	// (450 più i 100 ricaricati durante l'attesa precedente), quindi attende 950ms
+	limiter.Wait(1500)
+	if got := limiter.Throttled(); got != 1050*time.Millisecond {
+		t.Errorf("Attesa totale di 1.05s, ottenuta: %v", got)
+	}
+}
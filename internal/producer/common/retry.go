@@ -0,0 +1,369 @@
+package common
+
+import (
+	"csvreader/pkg/logger"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Header aggiunti ai messaggi inviati alla dead letter
+const (
+	HeaderDLQError         = "dlq-error"
+	HeaderDLQErrorCode     = "dlq-error-code"
+	HeaderDLQAttempts      = "dlq-attempts"
+	HeaderDLQOriginalTopic = "dlq-original-topic"
+)
+
+// RetryPolicy decide quante volte e con quale attesa viene ritentata la consegna di un messaggio fallito
+// con un errore ritentabile. L'attesa raddoppia a ogni tentativo, fino a MaxBackoff.
+// Il valore zero non ritenta.
+type RetryPolicy struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used by default: 3 retries waiting 100ms, 200ms and 400ms.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxRetries: 3, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second}
+}
+
+// Backoff returns the wait before the retry number 'attempt' (1-based).
+func (r RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := r.InitialBackoff
+	for i := 1; i < attempt && (r.MaxBackoff <= 0 || backoff < r.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+		backoff = r.MaxBackoff
+	}
+	return backoff
+}
+
+// retriableCodes sono gli errori transitori che librdkafka non marca come ritentabili
+// ma che si risolvono tipicamente con un nuovo tentativo (broker irraggiungibili, timeout, cambio di leader)
+var retriableCodes = map[kafka.ErrorCode]bool{
+	kafka.ErrMsgTimedOut:                  true,
+	kafka.ErrTimedOut:                     true,
+	kafka.ErrTimedOutQueue:                true,
+	kafka.ErrAllBrokersDown:               true,
+	kafka.ErrTransport:                    true,
+	kafka.ErrQueueFull:                    true,
+	kafka.ErrRequestTimedOut:              true,
+	kafka.ErrNotLeaderForPartition:        true,
+	kafka.ErrLeaderNotAvailable:           true,
+	kafka.ErrNotEnoughReplicas:            true,
+	kafka.ErrNotEnoughReplicasAfterAppend: true,
+}
+
+// Retriable reports whether a delivery failed with 'err' is worth retrying.
+func Retriable(err error) bool {
+	var kafkaErr kafka.Error
+	if !errors.As(err, &kafkaErr) {
+		return false
+	}
+	return kafkaErr.IsRetriable() || retriableCodes[kafkaErr.Code()]
+}
+
+// Redeliver produces again a message whose delivery failed with 'cause' to 'partition' (kafka.PartitionAny to let
+// librdkafka choose it again), waiting the policy's backoff before every attempt, until it is delivered, the error
+// is not retriable or the retries are exhausted. It returns the delivery report of the successful attempt (nil if
+// none), the number of delivery attempts made, the first included, and the last error (nil if delivered).
+// The retried message keeps its original timestamp, so the delivery latency of the report includes the retries.
+// Every call waits for its own delivery reports, so it can run concurrently with the normal production.
+//
+// A retried message lands after the messages produced while it was waiting, even in the same partition:
+// application-level retries do not preserve the per-key order. When the order matters, rely on librdkafka's
+// own retries with enable.idempotence=true and max.in.flight.requests.per.connection <= 5 (the durable profile).
+func (r RetryPolicy) Redeliver(producer *kafka.Producer, failed *kafka.Message, cause error, partition int32) (*kafka.Message, int, error) {
+	attempts := 1
+	if !Retriable(cause) {
+		return nil, attempts, cause
+	}
+
+	deliveryChan := make(chan kafka.Event, 1)
+	for retry := 1; retry <= r.MaxRetries; retry++ {
+		time.Sleep(r.Backoff(retry))
+		attempts++
+
+		msg := &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: failed.TopicPartition.Topic, Partition: partition},
+			Key:            failed.Key,
+			Value:          failed.Value,
+			Headers:        failed.Headers,
+			Timestamp:      failed.Timestamp,
+			Opaque:         failed.Opaque,
+		}
+		if err := producer.Produce(msg, deliveryChan); err != nil {
+			cause = err
+		} else if report := (<-deliveryChan).(*kafka.Message); report.TopicPartition.Error != nil {
+			cause = report.TopicPartition.Error
+		} else {
+			return report, attempts, nil
+		}
+
+		if !Retriable(cause) {
+			break
+		}
+	}
+	return nil, attempts, cause
+}
+
+// DeadLetter riceve i messaggi che non è stato possibile consegnare, con il payload originale
+// e gli header dell'errore, così la run può proseguire senza perderli.
+type DeadLetter interface {
+	// Send stores the failed message together with its last error and the number of delivery attempts.
+	Send(failed *kafka.Message, cause error, attempts int) error
+	// Close releases the dead letter, waiting for the pending writes.
+	Close() error
+}
+
+// deadLetterHeaders restituisce gli header originali con quelli che descrivono l'errore
+func deadLetterHeaders(failed *kafka.Message, cause error, attempts int) []kafka.Header {
+	headers := append([]kafka.Header(nil), failed.Headers...)
+	code := kafka.ErrUnknown
+	var kafkaErr kafka.Error
+	if errors.As(cause, &kafkaErr) {
+		code = kafkaErr.Code()
+	}
+	headers = append(headers,
+		kafka.Header{Key: HeaderDLQError, Value: []byte(cause.Error())},
+		kafka.Header{Key: HeaderDLQErrorCode, Value: []byte(code.String())},
+		kafka.Header{Key: HeaderDLQAttempts, Value: []byte(strconv.Itoa(attempts))},
+	)
+	if failed.TopicPartition.Topic != nil {
+		headers = append(headers, kafka.Header{Key: HeaderDLQOriginalTopic, Value: []byte(*failed.TopicPartition.Topic)})
+	}
+	return headers
+}
+
+// deadLetterTopic invia i messaggi falliti a un topic Kafka con un producer dedicato
+type deadLetterTopic struct {
+	producer *kafka.Producer
+	topic    string
+}
+
+// NewDeadLetterTopic returns a dead letter producing the failed messages to 'topic' with its own producer,
+// configured with 'tuning' (nil = librdkafka defaults), keeping their key and payload and adding the dlq-* headers.
+func NewDeadLetterTopic(bootstrapServers, topic string, tuning *Tuning) (DeadLetter, error) {
+	config, err := tuning.ConfigMap(bootstrapServers)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := kafka.NewProducer(&config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dead letter producer: %w", err)
+	}
+	return &deadLetterTopic{producer: producer, topic: topic}, nil
+}
+
+func (d *deadLetterTopic) Send(failed *kafka.Message, cause error, attempts int) error {
+	deliveryChan := make(chan kafka.Event, 1)
+	err := d.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &d.topic, Partition: kafka.PartitionAny},
+		Key:            failed.Key,
+		Value:          failed.Value,
+		Headers:        deadLetterHeaders(failed, cause, attempts),
+	}, deliveryChan)
+	if err != nil {
+		return fmt.Errorf("failed to produce to dead letter topic %s: %w", d.topic, err)
+	}
+	if report := (<-deliveryChan).(*kafka.Message); report.TopicPartition.Error != nil {
+		return fmt.Errorf("failed to deliver to dead letter topic %s: %w", d.topic, report.TopicPartition.Error)
+	}
+	return nil
+}
+
+func (d *deadLetterTopic) Close() error {
+	d.producer.Close()
+	return nil
+}
+
+// DeadLetterRecord è una riga del file di dead letter
+type DeadLetterRecord struct {
+	Topic   string            `json:"topic,omitempty"`
+	Key     []byte            `json:"key,omitempty"`
+	Value   []byte            `json:"value"` // payload originale, in base64 nel JSON
+	Headers map[string]string `json:"headers"`
+}
+
+// deadLetterFile scrive i messaggi falliti in un file JSON Lines
+type deadLetterFile struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// NewDeadLetterFile returns a dead letter appending the failed messages to the JSON Lines file 'path',
+// one DeadLetterRecord per line with the original payload and the original and dlq-* headers.
+func NewDeadLetterFile(path string) (DeadLetter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file %s: %w", path, err)
+	}
+	return &deadLetterFile{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (d *deadLetterFile) Send(failed *kafka.Message, cause error, attempts int) error {
+	record := DeadLetterRecord{Key: failed.Key, Value: failed.Value, Headers: make(map[string]string)}
+	if failed.TopicPartition.Topic != nil {
+		record.Topic = *failed.TopicPartition.Topic
+	}
+	for _, header := range deadLetterHeaders(failed, cause, attempts) {
+		record.Headers[header.Key] = string(header.Value)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.encoder.Encode(record); err != nil {
+		return fmt.Errorf("failed to write dead letter file: %w", err)
+	}
+	return nil
+}
+
+func (d *deadLetterFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.file.Close()
+}
+
+// Recovery gestisce le consegne fallite: le ritenta secondo Policy e, se falliscono ancora,
+// le invia a DeadLetter. È sicuro per l'uso concorrente. Il valore nil non ritenta né salva nulla.
+type Recovery struct {
+	Policy     RetryPolicy
+	DeadLetter DeadLetter // nil = nessuna dead letter, l'errore viene restituito
+	// KeepPartition ritenta i messaggi nella partizione in cui erano stati prodotti invece di lasciarla scegliere
+	// di nuovo a librdkafka: va impostato quando la partizione è scelta da un partitioner custom (vedi Keying.Partitioned)
+	KeepPartition bool
+
+	deadLettered atomic.Int64
+	redelivered  atomic.Int64
+}
+
+// Recover handles the delivery report of a failed message produced with 'producer'. It returns a nil error if the
+// message was eventually delivered or stored in the dead letter, so that the run can go on, and the delivery
+// error otherwise. When a retry delivered the message, it also returns its delivery report, e.g. for DeliveryStats.
+func (r *Recovery) Recover(producer *kafka.Producer, failed *kafka.Message) (*kafka.Message, error) {
+	cause := failed.TopicPartition.Error
+	if r == nil {
+		return nil, fmt.Errorf("delivery failed: %w", cause)
+	}
+
+	partition := kafka.PartitionAny
+	if r.KeepPartition {
+		partition = failed.TopicPartition.Partition
+	}
+	report, attempts, err := r.Policy.Redeliver(producer, failed, cause, partition)
+	if err == nil {
+		r.redelivered.Add(1)
+		return report, nil
+	}
+	if r.DeadLetter == nil {
+		return nil, fmt.Errorf("delivery failed after %d attempts: %w", attempts, err)
+	}
+
+	if dlErr := r.DeadLetter.Send(failed, err, attempts); dlErr != nil {
+		return nil, errors.Join(fmt.Errorf("delivery failed after %d attempts: %w", attempts, err), dlErr)
+	}
+	r.deadLettered.Add(1)
+	logger.WarningAsync("Message sent to the dead letter after ", attempts, " attempts: ", err)
+	return nil, nil
+}
+
+// Redelivered returns how many failed messages were delivered by a retry.
+func (r *Recovery) Redelivered() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.redelivered.Load()
+}
+
+// DeadLettered returns how many messages were stored in the dead letter.
+func (r *Recovery) DeadLettered() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.deadLettered.Load()
+}
+
+// MaxConcurrentRecoveries è il numero massimo di consegne fallite gestite in parallelo da un RecoveryPool:
+// ognuna attende il backoff dei retry, e con i broker irraggiungibili i report falliti possono essere centinaia di migliaia
+const MaxConcurrentRecoveries = 64
+
+// RecoveryPool gestisce in parallelo i delivery report falliti, che attendono il backoff dei retry,
+// con al più MaxConcurrentRecoveries goroutine. Il valore zero non è utilizzabile, vedi NewRecoveryPool.
+type RecoveryPool struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+}
+
+// NewRecoveryPool creates a pool running at most MaxConcurrentRecoveries recoveries at a time.
+func NewRecoveryPool() *RecoveryPool {
+	return &RecoveryPool{sem: make(chan struct{}, MaxConcurrentRecoveries)}
+}
+
+// Go runs 'handle' in a new goroutine, first waiting for a free slot if the pool is full:
+// the caller, which reads the delivery reports, slows down instead of piling up goroutines.
+func (p *RecoveryPool) Go(handle func()) {
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.sem
+			p.wg.Done()
+		}()
+		handle()
+	}()
+}
+
+// Wait waits for the recoveries started so far.
+func (p *RecoveryPool) Wait() {
+	p.wg.Wait()
+}
+
+// WaitForDeliveryReports reads 'n' delivery reports from 'deliveryChan' and passes each of them to 'handle',
+// returning the first error. The reports of failed messages are handled concurrently by a RecoveryPool,
+// so that the retries of a batch with many failures do not wait for each other.
+func WaitForDeliveryReports(deliveryChan <-chan kafka.Event, n int, handle func(kafka.Event) error) error {
+	pool := NewRecoveryPool()
+	var mu sync.Mutex
+	var firstErr error
+	setErr := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		e := <-deliveryChan
+		if !Failed(e) {
+			if err := handle(e); err != nil {
+				setErr(err)
+			}
+			continue
+		}
+		pool.Go(func() {
+			if err := handle(e); err != nil {
+				setErr(err)
+			}
+		})
+	}
+	pool.Wait()
+	return firstErr
+}
+
+// Failed reports whether the delivery report is about a message that was not delivered.
+func Failed(e kafka.Event) bool {
+	m, ok := e.(*kafka.Message)
+	return ok && m.TopicPartition.Error != nil
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, backoff := range expected {
+		if got := policy.Backoff(i + 1); got != backoff {
+			t.Errorf("Backoff del tentativo %d atteso: %v, ottenuto: %v", i+1, backoff, got)
+		}
+	}
+
+	if !Retriable(kafka.NewError(kafka.ErrMsgTimedOut, "timeout", false)) {
+		t.Errorf("Atteso errore ritentabile per il timeout del messaggio")
+	}
+	if Retriable(kafka.NewError(kafka.ErrMsgSizeTooLarge, "too large", false)) {
+		t.Errorf("Atteso errore non ritentabile per il messaggio troppo grande")
+	}
+}
+
+func TestRedeliverPartition(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del mock cluster: %v", err)
+	}
+	defer cluster.Close()
+	if err := cluster.CreateTopic("users", 3, 1); err != nil {
+		t.Fatalf("Errore durante la creazione del topic: %v", err)
+	}
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": cluster.BootstrapServers()})
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del producer: %v", err)
+	}
+	defer producer.Close()
+
+	// Il retry di un messaggio con la partizione scelta da un partitioner custom torna nella stessa partizione
+	topic := "users"
+	failed := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 2, Error: kafka.NewError(kafka.ErrMsgTimedOut, "timeout", false)},
+		Value:          []byte("payload"),
+	}
+	recovery := &Recovery{Policy: RetryPolicy{MaxRetries: 1, InitialBackoff: time.Millisecond}, KeepPartition: true}
+	report, err := recovery.Recover(producer, failed)
+	if err != nil {
+		t.Fatalf("Errore inatteso nel retry: %v", err)
+	}
+	// Il delivery report del retry viene restituito, per le statistiche per partizione
+	if report == nil || report.TopicPartition.Partition != 2 || report.TopicPartition.Offset != 0 {
+		t.Errorf("Atteso il delivery report del retry nella partizione 2, ottenuto: %v", report)
+	}
+	if _, high, err := producer.QueryWatermarkOffsets(topic, 2, 5000); err != nil || high != 1 {
+		t.Errorf("Atteso il messaggio ritentato nella partizione 2, offset: %d, %v", high, err)
+	}
+}
+
+func TestRecoveryPool(t *testing.T) {
+	// Le goroutine che gestiscono le consegne fallite non superano mai MaxConcurrentRecoveries
+	pool := NewRecoveryPool()
+	var running, peak atomic.Int64
+	for i := 0; i < 4*MaxConcurrentRecoveries; i++ {
+		pool.Go(func() {
+			n := running.Add(1)
+			for {
+				current := peak.Load()
+				if n <= current || peak.CompareAndSwap(current, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	pool.Wait()
+	if peak.Load() > MaxConcurrentRecoveries {
+		t.Errorf("Goroutine concorrenti attese al massimo %d, ottenute: %d", MaxConcurrentRecoveries, peak.Load())
+	}
+}
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"csvreader/internal/checkpoint"
+	"csvreader/internal/models"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Sink è la destinazione dei batch di utenti della pipeline. È implementato dai producer JSON e Avro
+// e da MemorySink, che registra i messaggi invece di inviarli.
+type Sink interface {
+	// ProduceBatch converts the users into messages and produces them, see the producers for the delivery guarantees.
+	ProduceBatch(users []models.User, correlationID string) error
+	// Flush waits for the messages still in flight and returns the delivery errors not reported yet.
+	Flush() error
+	// Close flushes and releases the sink.
+	Close() error
+	// Stats returns the counters of the messages produced so far.
+	Stats() Stats
+}
+
+// Stats contiene i contatori dei messaggi di un Sink
+type Stats struct {
+	Produced     int64         // messaggi accodati
+	Bytes        int64         // byte dei payload accodati
+	Delivered    int64         // consegne confermate, comprese quelle riuscite dopo un retry
+	Failed       int64         // consegne fallite definitivamente
+	DeadLettered int64         // messaggi finiti nella dead letter
+	Throttled    time.Duration // attesa imposta dal RateLimiter
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("produced %d messages (%d bytes), delivered %d, failed %d, dead-lettered %d, throttled %s",
+		s.Produced, s.Bytes, s.Delivered, s.Failed, s.DeadLettered, s.Throttled)
+}
+
+// MessageBuilder converte un utente nel messaggio Kafka da produrre (payload, chiave, partizione, header).
+// L'Opaque del messaggio è la riga dell'utente (models.User.RowOffset), confermata sul checkpoint alla consegna.
+type MessageBuilder func(user models.User, correlationID string) (*kafka.Message, error)
+
+// Counters sono i contatori condivisi dalle implementazioni di Sink, sicuri per l'uso concorrente
+type Counters struct {
+	produced  atomic.Int64
+	bytes     atomic.Int64
+	delivered atomic.Int64
+	failed    atomic.Int64
+}
+
+// AddProduced counts a message enqueued with a payload of 'bytes' bytes.
+func (c *Counters) AddProduced(bytes int) {
+	c.produced.Add(1)
+	c.bytes.Add(int64(bytes))
+}
+
+// AddDelivered counts a confirmed delivery.
+func (c *Counters) AddDelivered() {
+	c.delivered.Add(1)
+}
+
+// AddFailed counts a delivery failed for good.
+func (c *Counters) AddFailed() {
+	c.failed.Add(1)
+}
+
+// Stats returns the counters, completed with the messages retried or dead-lettered by 'recovery' (may be nil).
+func (c *Counters) Stats(recovery *Recovery) Stats {
+	return Stats{
+		Produced:     c.produced.Load(),
+		Bytes:        c.bytes.Load(),
+		Delivered:    c.delivered.Load() + recovery.Redelivered(),
+		Failed:       c.failed.Load(),
+		DeadLettered: recovery.DeadLettered(),
+	}
+}
+
+// MemorySink è un Sink che registra in memoria i messaggi che sarebbero stati inviati, costruiti con lo
+// stesso MessageBuilder del producer, per testare la pipeline senza un broker. Ogni messaggio è considerato
+// consegnato appena prodotto.
+type MemorySink struct {
+	build      MessageBuilder
+	checkpoint *checkpoint.Checkpoint
+	counters   Counters
+
+	mu       sync.Mutex
+	messages []*kafka.Message
+	closed   bool
+}
+
+// NewMemorySink creates a sink recording the messages built by 'build', e.g. the JSON producer's NewMessageBuilder.
+func NewMemorySink(build MessageBuilder) *MemorySink {
+	return &MemorySink{build: build}
+}
+
+// SetCheckpoint makes the sink confirm on 'cp' the row offset of every recorded message, like the producers.
+func (s *MemorySink) SetCheckpoint(cp *checkpoint.Checkpoint) {
+	s.checkpoint = cp
+}
+
+// ProduceBatch builds and records a message per user.
+func (s *MemorySink) ProduceBatch(users []models.User, correlationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("produce on closed sink")
+	}
+
+	for _, user := range users {
+		msg, err := s.build(user, correlationID)
+		if err != nil {
+			return err
+		}
+		s.messages = append(s.messages, msg)
+		s.counters.AddProduced(len(msg.Value))
+		s.counters.AddDelivered()
+
+		if rowOffset, ok := msg.Opaque.(int64); ok && rowOffset > 0 && s.checkpoint != nil {
+			s.checkpoint.Confirm(rowOffset)
+		}
+	}
+	return nil
+}
+
+// Flush is a no-op: the messages are recorded synchronously.
+func (s *MemorySink) Flush() error {
+	return nil
+}
+
+// Close marks the sink as closed: later batches fail.
+func (s *MemorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Stats returns the counters of the recorded messages.
+func (s *MemorySink) Stats() Stats {
+	return s.counters.Stats(nil)
+}
+
+// Messages returns a copy of the recorded messages, in production order.
+func (s *MemorySink) Messages() []*kafka.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*kafka.Message(nil), s.messages...)
+}
+
+// Closed reports whether Close has been called.
+func (s *MemorySink) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// transactionTimeout è il tempo massimo concesso a ogni operazione transazionale (init, commit, abort)
+const transactionTimeout = 30 * time.Second
+
+// WithTransactionalID returns a copy of the tuning that makes the producer transactional with 'transactionalID'.
+// Transactions require the idempotent producer, so enable.idempotence is forced as well.
+func (t *Tuning) WithTransactionalID(transactionalID string) *Tuning {
+	tuning := &Tuning{Overrides: make(map[string]string)}
+	if t != nil {
+		tuning.Profile = t.Profile
+		for key, value := range t.Overrides {
+			tuning.Overrides[key] = value
+		}
+	}
+	tuning.Overrides["transactional.id"] = transactionalID
+	tuning.Overrides["enable.idempotence"] = "true"
+	return tuning
+}
+
+// InitTransactions registers the transactional.id of the producer with the cluster,
+// fencing any previous producer with the same id. It must be called once, before the first transaction.
+func InitTransactions(producer *kafka.Producer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), transactionTimeout)
+	defer cancel()
+
+	if err := producer.InitTransactions(ctx); err != nil {
+		return fmt.Errorf("failed to init transactions: %w", err)
+	}
+	return nil
+}
+
+// RunTransaction runs 'produce' inside a Kafka transaction. 'produce' must return only after the delivery
+// reports of all its messages: the transaction is committed if it returns nil, aborted otherwise, so that
+// read_committed consumers see either all the messages or none of them.
+func RunTransaction(producer *kafka.Producer, produce func() error) error {
+	if err := producer.BeginTransaction(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := produce(); err != nil {
+		return abortTransaction(producer, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), transactionTimeout)
+	defer cancel()
+	if err := producer.CommitTransaction(ctx); err != nil {
+		var kafkaErr kafka.Error
+		if errors.As(err, &kafkaErr) && kafkaErr.TxnRequiresAbort() {
+			return abortTransaction(producer, fmt.Errorf("failed to commit transaction: %w", err))
+		}
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// abortTransaction annulla la transazione corrente e restituisce l'errore che l'ha causata
+func abortTransaction(producer *kafka.Producer, cause error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), transactionTimeout)
+	defer cancel()
+
+	if err := producer.AbortTransaction(ctx); err != nil {
+		return errors.Join(cause, fmt.Errorf("failed to abort transaction: %w", err))
+	}
+	return fmt.Errorf("transaction aborted: %w", cause)
+}
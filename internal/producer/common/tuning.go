@@ -0,0 +1,145 @@
+package common
+
+import (
+	"csvreader/pkg/logger"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Profili di tuning di librdkafka
+const (
+	ProfileDefault    = ""            // i default di librdkafka
+	ProfileThroughput = "throughput"  // batch grandi e compressi, attesa più lunga prima dell'invio
+	ProfileLowLatency = "low-latency" // invio immediato, senza compressione
+	ProfileDurable    = "durable"     // conferma da tutte le repliche e producer idempotente
+)
+
+// profiles contiene le proprietà di librdkafka impostate da ogni profilo
+var profiles = map[string]map[string]string{
+	ProfileDefault: {},
+	ProfileThroughput: {
+		"linger.ms":                    "50",
+		"batch.size":                   "1000000",
+		"compression.type":             "lz4",
+		"acks":                         "1",
+		"queue.buffering.max.messages": "1000000",
+		"queue.buffering.max.kbytes":   "1048576",
+	},
+	ProfileLowLatency: {
+		"linger.ms":        "0",
+		"batch.size":       "16384",
+		"compression.type": "none",
+		"acks":             "1",
+	},
+	ProfileDurable: {
+		"linger.ms":          "5",
+		"compression.type":   "zstd",
+		"acks":               "all",
+		"enable.idempotence": "true",
+	},
+}
+
+// Tuning è la configurazione del producer: un profilo e le proprietà di librdkafka che lo sovrascrivono
+// (es. linger.ms, batch.size, compression.type, acks, enable.idempotence, queue.buffering.max.messages).
+// Il valore nil equivale al profilo di default.
+type Tuning struct {
+	Profile   string            `json:"profile"`
+	Overrides map[string]string `json:"overrides"`
+}
+
+// LoadTuning reads the producer tuning from a JSON file such as
+// {"profile": "throughput", "overrides": {"linger.ms": "20"}} and checks its profile.
+func LoadTuning(path string) (*Tuning, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read producer config %s: %w", path, err)
+	}
+
+	var tuning Tuning
+	if err := json.Unmarshal(data, &tuning); err != nil {
+		return nil, fmt.Errorf("invalid producer config %s: %w", path, err)
+	}
+	if _, ok := profiles[tuning.Profile]; !ok {
+		return nil, unknownProfileError(tuning.Profile)
+	}
+	return &tuning, nil
+}
+
+// ParseOverrides parses overrides written as "key=value,key=value", e.g. "linger.ms=20,acks=all".
+// It also parses the topic configs of TopicSpec, e.g. "retention.ms=604800000,cleanup.policy=delete".
+func ParseOverrides(s string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return overrides, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid property %q: expected key=value", pair)
+		}
+		overrides[key] = value
+	}
+	return overrides, nil
+}
+
+// ConfigMap returns the librdkafka configuration for 'bootstrapServers': the properties of the profile
+// with the overrides applied on top. Unknown properties are reported by librdkafka when the producer is created.
+func (t *Tuning) ConfigMap(bootstrapServers string) (kafka.ConfigMap, error) {
+	var tuning Tuning
+	if t != nil {
+		tuning = *t
+	}
+
+	profile, ok := profiles[tuning.Profile]
+	if !ok {
+		return nil, unknownProfileError(tuning.Profile)
+	}
+
+	config := kafka.ConfigMap{
+		"bootstrap.servers": bootstrapServers,
+		// i delivery report riportano anche gli header, per ritentare o inviare alla dead letter il messaggio originale
+		"go.delivery.report.fields": "key,value,headers",
+	}
+	for key, value := range profile {
+		config[key] = value
+	}
+	for key, value := range tuning.Overrides {
+		config[key] = value
+	}
+	return config, nil
+}
+
+// LogConfig logs the effective producer configuration, one property per line sorted by name.
+// The values of the credentials (see maskedValue) are masked.
+func LogConfig(config kafka.ConfigMap) {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	logger.InfoAsync("Effective producer config:")
+	for _, key := range keys {
+		logger.InfoAsync("  ", key, "=", maskedValue(key, config[key]))
+	}
+}
+
+// maskedValue restituisce il valore della proprietà da scrivere nel log: quelle che possono contenere credenziali
+// (es. sasl.password, ssl.key.password, sasl.oauthbearer.client.secret) vengono mascherate
+func maskedValue(key string, value kafka.ConfigValue) kafka.ConfigValue {
+	for _, sensitive := range []string{"password", "secret", "key"} {
+		if strings.Contains(strings.ToLower(key), sensitive) {
+			return "********"
+		}
+	}
+	return value
+}
+
+func unknownProfileError(profile string) error {
+	return fmt.Errorf("unknown producer profile %q: use %s, %s or %s", profile, ProfileThroughput, ProfileLowLatency, ProfileDurable)
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestTuning(t *testing.T) {
+	config, err := (*Tuning)(nil).ConfigMap("localhost:9092")
+	if err != nil || len(config) != 2 || config["bootstrap.servers"] != "localhost:9092" {
+		t.Errorf("Attesi solo bootstrap.servers e i campi dei delivery report senza tuning, ottenuto: %v, %v", config, err)
+	}
+
+	overrides, err := ParseOverrides("linger.ms=20, acks=all")
+	if err != nil {
+		t.Fatalf("Errore durante la lettura degli override: %v", err)
+	}
+	config, err = (&Tuning{Profile: ProfileThroughput, Overrides: overrides}).ConfigMap("localhost:9092")
+	if err != nil {
+		t.Fatalf("Errore durante la creazione della configurazione: %v", err)
+	}
+	expected := map[string]string{"linger.ms": "20", "acks": "all", "compression.type": "lz4"}
+	for key, value := range expected {
+		if config[key] != value {
+			t.Errorf("Proprietà %s attesa: %s, ottenuta: %v", key, value, config[key])
+		}
+	}
+
+	if _, err := (&Tuning{Profile: "fastest"}).ConfigMap("localhost:9092"); err == nil {
+		t.Errorf("Atteso errore per un profilo sconosciuto, ma non si è verificato")
+	}
+	if _, err := ParseOverrides("linger.ms"); err == nil {
+		t.Errorf("Atteso errore per un override senza valore, ma non si è verificato")
+	}
+
+	// Le credenziali non finiscono nel log della configurazione
+	for _, key := range []string{"sasl.password", "ssl.key.password", "sasl.oauthbearer.client.secret", "ssl.key.pem"} {
+		if value := maskedValue(key, "s3cr3t"); value == "s3cr3t" {
+			t.Errorf("Valore di %s atteso mascherato, ottenuto: %v", key, value)
+		}
+	}
+	if value := maskedValue("linger.ms", 20); value != 20 {
+		t.Errorf("Valore di linger.ms atteso: 20, ottenuto: %v", value)
+	}
+
+	tuning, err := LoadTuning("../../../resources/config/producer.json")
+	if err != nil || tuning.Profile != ProfileThroughput {
+		t.Errorf("Errore durante la lettura della configurazione di esempio: %v, %v", tuning, err)
+	}
+}
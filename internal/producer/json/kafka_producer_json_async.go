@@ -0,0 +1,12 @@
+package producer
+
+import "csvreader/internal/producer/common"
+
+// NewAsyncProducer creates a JSON producer in fully asynchronous mode, see common.NewAsyncKafkaSink.
+func NewAsyncProducer(bootstrapServers, topic string, tuning *common.Tuning) (*Producer, error) {
+	sink, err := common.NewAsyncKafkaSink(bootstrapServers, topic, tuning, NewMessageBuilder)
+	if err != nil {
+		return nil, err
+	}
+	return &Producer{sink}, nil
+}
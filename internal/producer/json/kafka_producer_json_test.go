@@ -0,0 +1,288 @@
+package producer
+
+import (
+	"csvreader/internal/checkpoint"
+	"csvreader/internal/models"
+	"csvreader/internal/producer/common"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func TestAsyncProducer(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del mock cluster: %v", err)
+	}
+	defer cluster.Close()
+
+	p, err := NewAsyncProducer(cluster.BootstrapServers(), "users", nil)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del producer: %v", err)
+	}
+	cp := checkpoint.New(filepath.Join(t.TempDir(), "checkpoint.json"), "users.csv", 0)
+	p.SetCheckpoint(cp)
+
+	// Due batch accodati senza attendere i delivery report
+	for batch := 0; batch < 2; batch++ {
+		var users []models.User
+		for i := 1; i <= 50; i++ {
+			offset := int64(batch*50 + i)
+			users = append(users, models.User{ID: int(offset), NomeUtente: "user", Email: "user@example.com", RowOffset: offset})
+		}
+		if err := p.ProduceBatch(users, "correlation"); err != nil {
+			t.Fatalf("Errore durante l'accodamento del batch: %v", err)
+		}
+	}
+
+	if err := p.Flush(); err != nil {
+		t.Errorf("Errore inatteso dal Flush: %v", err)
+	}
+	if cp.Offset() != 100 {
+		t.Errorf("Offset del checkpoint atteso: 100, ottenuto: %d", cp.Offset())
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Errore inatteso dal Close: %v", err)
+	}
+}
+
+func TestPartitionStats(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del mock cluster: %v", err)
+	}
+	defer cluster.Close()
+	if err := cluster.CreateTopic("users", 2, 1); err != nil {
+		t.Fatalf("Errore durante la creazione del topic: %v", err)
+	}
+
+	p, err := NewProducer(cluster.BootstrapServers(), "users", nil)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del producer: %v", err)
+	}
+	defer p.Close()
+	// Gli id pari vanno sulla partizione 0, i dispari sulla 1
+	keying, err := common.NewKeying(common.KeyID, "id-modulo")
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del keying: %v", err)
+	}
+	if err := p.SetKeying(keying); err != nil {
+		t.Fatalf("Errore durante la configurazione delle chiavi: %v", err)
+	}
+
+	var users []models.User
+	for i := 1; i <= 5; i++ {
+		users = append(users, models.User{ID: i, NomeUtente: "user", Email: "user@example.com"})
+	}
+	if err := p.ProduceBatch(users, "correlation"); err != nil {
+		t.Fatalf("Errore durante la produzione del batch: %v", err)
+	}
+
+	partitions := p.PartitionStats()
+	if len(partitions) != 2 {
+		t.Fatalf("Attese statistiche per 2 partizioni, ottenute: %v", partitions)
+	}
+	for i, expected := range []int64{2, 3} {
+		stats := partitions[i]
+		if stats.Topic != "users" || stats.Partition != int32(i) || stats.Messages != expected {
+			t.Errorf("Attesi %d messaggi sulla partizione %d, ottenuto: %v", expected, i, stats)
+		}
+		if stats.FirstOffset != 0 || stats.LastOffset != kafka.Offset(expected-1) {
+			t.Errorf("Attesi gli offset 0-%d sulla partizione %d, ottenuto: %v", expected-1, i, stats)
+		}
+		if stats.P50 > stats.P95 || stats.P95 > stats.P99 {
+			t.Errorf("Percentili non ordinati sulla partizione %d: %v", i, stats)
+		}
+	}
+}
+
+func TestPartitionStatsRedelivered(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del mock cluster: %v", err)
+	}
+	defer cluster.Close()
+	if err := cluster.CreateTopic("users", 1, 1); err != nil {
+		t.Fatalf("Errore durante la creazione del topic: %v", err)
+	}
+
+	// Con il broker giù il primo tentativo scade, il broker torna su durante il backoff dei retry
+	tuning := &common.Tuning{Overrides: map[string]string{"message.timeout.ms": "200"}}
+	p, err := NewProducer(cluster.BootstrapServers(), "users", tuning)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del producer: %v", err)
+	}
+	defer p.Close()
+	p.SetRecovery(&common.Recovery{
+		Policy: common.RetryPolicy{MaxRetries: 5, InitialBackoff: 200 * time.Millisecond, MaxBackoff: time.Second},
+	})
+
+	if err := cluster.SetBrokerDown(1); err != nil {
+		t.Fatalf("Errore durante l'arresto del broker: %v", err)
+	}
+	time.AfterFunc(300*time.Millisecond, func() { cluster.SetBrokerUp(1) })
+	users := []models.User{
+		{ID: 1, NomeUtente: "mario", Email: "mario@example.com"},
+		{ID: 2, NomeUtente: "luigi", Email: "luigi@example.com"},
+	}
+	if err := p.ProduceBatch(users, "correlation"); err != nil {
+		t.Fatalf("Errore inatteso: i messaggi dovevano essere consegnati dai retry: %v", err)
+	}
+
+	// Le consegne riuscite con un retry compaiono anche nelle statistiche per partizione
+	if stats := p.Stats(); stats.Delivered != 2 {
+		t.Errorf("Consegne attese: 2, ottenute: %v", stats)
+	}
+	partitions := p.PartitionStats()
+	if len(partitions) != 1 || partitions[0].Messages != 2 {
+		t.Errorf("Attesi 2 messaggi nelle statistiche per partizione, ottenuti: %v", partitions)
+	}
+}
+
+func TestTransactionalProducer(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del mock cluster: %v", err)
+	}
+	defer cluster.Close()
+	// Una sola partizione: il consumer legge i messaggi nell'ordine in cui sono stati prodotti
+	if err := cluster.CreateTopic("users", 1, 1); err != nil {
+		t.Fatalf("Errore durante la creazione del topic: %v", err)
+	}
+
+	// Un messaggio oltre message.max.bytes fa fallire il secondo batch a metà
+	tuning := &common.Tuning{Overrides: map[string]string{"message.max.bytes": "1000"}}
+	p, err := NewTransactionalProducer(cluster.BootstrapServers(), "users", tuning, "csvreader-test")
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del producer transazionale: %v", err)
+	}
+	defer p.Close()
+	cp := checkpoint.New(filepath.Join(t.TempDir(), "checkpoint.json"), "users.csv", 0)
+	p.SetCheckpoint(cp)
+
+	committed := []models.User{
+		{ID: 1, NomeUtente: "mario", Email: "mario@example.com", RowOffset: 1},
+		{ID: 2, NomeUtente: "luigi", Email: "luigi@example.com", RowOffset: 2},
+		{ID: 3, NomeUtente: "anna", Email: "anna@example.com", RowOffset: 3},
+	}
+	if err := p.ProduceBatch(committed, "correlation"); err != nil {
+		t.Fatalf("Errore durante la produzione del batch transazionale: %v", err)
+	}
+
+	aborted := []models.User{
+		{ID: 4, NomeUtente: "paolo", Email: "paolo@example.com", RowOffset: 4},
+		{ID: 5, NomeUtente: strings.Repeat("x", 2000), Email: "big@example.com", RowOffset: 5},
+	}
+	if err := p.ProduceBatch(aborted, "correlation"); err == nil {
+		t.Fatalf("Atteso errore per il batch con un messaggio troppo grande, ma non si è verificato")
+	}
+	if cp.Offset() != 3 {
+		t.Errorf("Offset del checkpoint atteso: 3, ottenuto: %d", cp.Offset())
+	}
+
+	// Il batch confermato è leggibile per intero con read_committed.
+	// Il mock cluster di librdkafka non scrive i marker di fine transazione, quindi non filtra i messaggi
+	// delle transazioni annullate: per il batch fallito si verificano solo l'abort e il checkpoint (sopra).
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": cluster.BootstrapServers(),
+		"group.id":          "test",
+		"auto.offset.reset": "earliest",
+		"isolation.level":   "read_committed",
+	})
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del consumer: %v", err)
+	}
+	defer consumer.Close()
+	if err := consumer.Subscribe("users", nil); err != nil {
+		t.Fatalf("Errore durante la sottoscrizione del topic: %v", err)
+	}
+
+	for _, user := range committed {
+		msg, err := consumer.ReadMessage(5 * time.Second)
+		if err != nil {
+			t.Fatalf("Errore durante la lettura del batch confermato: %v", err)
+		}
+		if expected := fmt.Sprintf(`"id":%d,`, user.ID); !strings.Contains(string(msg.Value), expected) {
+			t.Errorf("Messaggio atteso con %s, ottenuto: %s", expected, msg.Value)
+		}
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del mock cluster: %v", err)
+	}
+	defer cluster.Close()
+
+	// Con il broker giù ogni tentativo scade dopo message.timeout.ms
+	tuning := &common.Tuning{Overrides: map[string]string{"message.timeout.ms": "200"}}
+	p, err := NewProducer(cluster.BootstrapServers(), "users", tuning)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del producer: %v", err)
+	}
+	defer p.Close()
+	cp := checkpoint.New(filepath.Join(t.TempDir(), "checkpoint.json"), "users.csv", 0)
+	p.SetCheckpoint(cp)
+
+	deadLetterFile := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	deadLetter, err := common.NewDeadLetterFile(deadLetterFile)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione della dead letter: %v", err)
+	}
+	recovery := &common.Recovery{
+		Policy:     common.RetryPolicy{MaxRetries: 2, InitialBackoff: 10 * time.Millisecond},
+		DeadLetter: deadLetter,
+	}
+	p.SetRecovery(recovery)
+
+	if err := cluster.SetBrokerDown(1); err != nil {
+		t.Fatalf("Errore durante l'arresto del broker: %v", err)
+	}
+	users := []models.User{
+		{ID: 1, NomeUtente: "mario", Email: "mario@example.com", RowOffset: 1},
+		{ID: 2, NomeUtente: "luigi", Email: "luigi@example.com", RowOffset: 2},
+	}
+	if err := p.ProduceBatch(users, "correlation"); err != nil {
+		t.Fatalf("Errore inatteso: i messaggi falliti dovevano finire nella dead letter: %v", err)
+	}
+	if err := deadLetter.Close(); err != nil {
+		t.Fatalf("Errore durante la chiusura della dead letter: %v", err)
+	}
+
+	if recovery.DeadLettered() != 2 {
+		t.Errorf("Messaggi nella dead letter attesi: 2, ottenuti: %d", recovery.DeadLettered())
+	}
+	// I messaggi nella dead letter non sono stati consegnati: alla ripresa vengono inviati di nuovo
+	if cp.Offset() != 0 {
+		t.Errorf("Offset del checkpoint atteso: 0, ottenuto: %d", cp.Offset())
+	}
+
+	data, err := os.ReadFile(deadLetterFile)
+	if err != nil {
+		t.Fatalf("Errore durante la lettura della dead letter: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Righe della dead letter attese: 2, ottenute: %d", len(lines))
+	}
+	for _, line := range lines {
+		var record common.DeadLetterRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Errore durante la lettura del record della dead letter: %v", err)
+		}
+		if record.Topic != "users" || !strings.Contains(string(record.Value), `"email":`) {
+			t.Errorf("Record della dead letter senza topic o payload originale: %+v", record)
+		}
+		if record.Headers["correlation-id"] != "correlation" || record.Headers[common.HeaderDLQAttempts] != "3" ||
+			record.Headers[common.HeaderDLQErrorCode] != kafka.ErrMsgTimedOut.String() {
+			t.Errorf("Header della dead letter inattesi: %v", record.Headers)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package producer
+
+import "csvreader/internal/producer/common"
+
+// NewTransactionalProducer creates a JSON producer in transactional mode, see common.NewTransactionalKafkaSink.
+func NewTransactionalProducer(bootstrapServers, topic string, tuning *common.Tuning, transactionalID string) (*Producer, error) {
+	sink, err := common.NewTransactionalKafkaSink(bootstrapServers, topic, tuning, transactionalID, NewMessageBuilder)
+	if err != nil {
+		return nil, err
+	}
+	return &Producer{sink}, nil
+}
@@ -0,0 +1,94 @@
+package protobuf
+
+import (
+	"csvreader/internal/models"
+	"csvreader/internal/models/userpb"
+	"csvreader/internal/producer/common"
+	"csvreader/internal/schemaregistry"
+	"csvreader/pkg/logger"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"google.golang.org/protobuf/proto"
+)
+
+// messageIndexes è la posizione del messaggio User in user.proto, per il wire format Confluent
+var messageIndexes = []int{0}
+
+// Producer produce gli utenti serializzati come messaggi userpb.User (vedi NewMessageBuilder): produzione,
+// delivery report, checkpoint e transazioni sono quelli di common.KafkaSink
+type Producer struct {
+	*common.KafkaSink
+}
+
+// Producer implementa common.Sink
+var _ common.Sink = (*Producer)(nil)
+
+// NewProducerProtobuf creates a new Kafka producer that serializes users as userpb.User Protobuf messages.
+// 'tuning' selects the librdkafka tuning profile and overrides (nil = librdkafka defaults).
+func NewProducerProtobuf(bootstrapServers, topic string, tuning *common.Tuning) (*Producer, error) {
+	sink, err := common.NewKafkaSink(bootstrapServers, topic, tuning, newBuilder(0))
+	if err != nil {
+		return nil, err
+	}
+	return &Producer{sink}, nil
+}
+
+// NewTransactionalProducerProtobuf creates a Protobuf producer in transactional mode with 'transactionalID':
+// every ProduceBatch call is committed as one Kafka transaction and aborted on any delivery error.
+func NewTransactionalProducerProtobuf(bootstrapServers, topic string, tuning *common.Tuning, transactionalID string) (*Producer, error) {
+	sink, err := common.NewTransactionalKafkaSink(bootstrapServers, topic, tuning, transactionalID, newBuilder(0))
+	if err != nil {
+		return nil, err
+	}
+	return &Producer{sink}, nil
+}
+
+// Format è il formato dei payload del producer Protobuf, per gli header content-type e schema-version
+var Format = common.PayloadFormat{ContentType: "application/x-protobuf", SchemaVersion: "csvreader.User/1"}
+
+// NewMessageBuilder returns the builder of the messages produced to 'topic': the user serialized as a userpb.User
+// Protobuf message, framed in Confluent wire format with 'schemaID' and the message index (0 = bare Protobuf),
+// keyed according to 'keying' (nil = no key), with a correlation-id header followed by the provenance headers
+// selected by 'headers' (nil = source-file only).
+// It is exported so that a common.MemorySink can record exactly the messages the producer would send.
+func NewMessageBuilder(topic string, schemaID int, keying *common.Keying, headers *common.Headers) common.MessageBuilder {
+	return func(user models.User, correlationID string) (*kafka.Message, error) {
+		payload, err := proto.Marshal(userpb.FromUser(user))
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize Protobuf message: %w", err)
+		}
+		if schemaID > 0 {
+			payload = schemaregistry.FrameProtobuf(schemaID, messageIndexes, payload)
+		}
+
+		key, partition := keying.Route(user)
+		return &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition},
+			Key:            key,
+			Value:          payload,
+			Headers:        headers.Build(correlationID, &user, payload, Format),
+			Opaque:         user.RowOffset,
+		}, nil
+	}
+}
+
+// newBuilder restituisce la common.BuilderFactory dei messaggi Protobuf incorniciati con 'schemaID'
+func newBuilder(schemaID int) common.BuilderFactory {
+	return func(topic string, keying *common.Keying, headers *common.Headers) common.MessageBuilder {
+		return NewMessageBuilder(topic, schemaID, keying, headers)
+	}
+}
+
+// SetSchemaRegistry makes the producer frame every payload in Confluent wire format for Protobuf (magic byte,
+// 4-byte schema ID and message index). The ID of userpb.Schema under 'subject' is looked up in the registry,
+// registering the schema first if 'autoRegister' is set.
+func (p *Producer) SetSchemaRegistry(client *schemaregistry.Client, subject string, autoRegister bool) error {
+	id, err := client.SchemaID(subject, schemaregistry.Schema{Schema: userpb.Schema, SchemaType: schemaregistry.TypeProtobuf}, autoRegister)
+	if err != nil {
+		return err
+	}
+	logger.InfoAsync("Protobuf payloads framed with schema ID ", id, " of subject ", subject)
+	p.SetBuilder(newBuilder(id))
+	return nil
+}
@@ -0,0 +1,87 @@
+package protobuf
+
+import (
+	"csvreader/internal/checkpoint"
+	"csvreader/internal/models"
+	"csvreader/internal/models/userpb"
+	"csvreader/internal/schemaregistry"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProducerRoundTrip(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del mock cluster: %v", err)
+	}
+	defer cluster.Close()
+	// Una sola partizione: il consumer legge i messaggi nell'ordine in cui sono stati prodotti
+	if err := cluster.CreateTopic("users", 1, 1); err != nil {
+		t.Fatalf("Errore durante la creazione del topic: %v", err)
+	}
+	registry := schemaregistry.NewMockRegistry()
+	defer registry.Close()
+
+	p, err := NewProducerProtobuf(cluster.BootstrapServers(), "users", nil)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del producer: %v", err)
+	}
+	defer p.Close()
+	client := schemaregistry.NewClient(registry.URL())
+	if err := p.SetSchemaRegistry(client, schemaregistry.ValueSubject("users"), true); err != nil {
+		t.Fatalf("Errore durante la registrazione dello schema: %v", err)
+	}
+	cp := checkpoint.New(filepath.Join(t.TempDir(), "checkpoint.json"), "users.csv", 0)
+	p.SetCheckpoint(cp)
+
+	users := []models.User{
+		{ID: 1, NomeUtente: "mario", Email: "mario@example.com", RowOffset: 1},
+		{ID: 2, NomeUtente: "luigi", Email: "luigi@example.com", RowOffset: 2},
+	}
+	if err := p.ProduceBatch(users, "correlation"); err != nil {
+		t.Fatalf("Errore durante la produzione del batch: %v", err)
+	}
+	if cp.Offset() != 2 {
+		t.Errorf("Offset del checkpoint atteso: 2, ottenuto: %d", cp.Offset())
+	}
+	if stats := p.Stats(); stats.Produced != 2 || stats.Delivered != 2 {
+		t.Errorf("Statistiche inattese: %v", stats)
+	}
+
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": cluster.BootstrapServers(),
+		"group.id":          "test",
+		"auto.offset.reset": "earliest",
+	})
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del consumer: %v", err)
+	}
+	defer consumer.Close()
+	if err := consumer.Subscribe("users", nil); err != nil {
+		t.Fatalf("Errore durante la sottoscrizione al topic: %v", err)
+	}
+
+	// Un consumer decodifica i payload in wire format con lo schema ID e il messaggio User
+	for i, expected := range users {
+		msg, err := consumer.ReadMessage(10 * time.Second)
+		if err != nil {
+			t.Fatalf("Errore durante la lettura del messaggio %d: %v", i, err)
+		}
+		id, indexes, payload, err := schemaregistry.UnframeProtobuf(msg.Value)
+		if err != nil || id != 1 || len(indexes) != 1 || indexes[0] != 0 {
+			t.Fatalf("Atteso wire format con ID 1 e indice [0], ottenuti: %d, %v, %v", id, indexes, err)
+		}
+		var message userpb.User
+		if err := proto.Unmarshal(payload, &message); err != nil {
+			t.Fatalf("Errore durante la decodifica del messaggio Protobuf: %v", err)
+		}
+		expected.RowOffset = 0 // i campi di provenienza non vengono serializzati
+		if message.ToUser() != expected {
+			t.Errorf("Utente atteso: %v, ottenuto: %v", expected, message.ToUser())
+		}
+	}
+}
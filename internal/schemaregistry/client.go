@@ -0,0 +1,273 @@
+// Package schemaregistry è un client minimale della Confluent Schema Registry (REST API v1)
+// e implementa il wire format Confluent dei payload.
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tipi di schema supportati dalla registry: il vuoto equivale ad AVRO
+const (
+	TypeAvro     = ""
+	TypeProtobuf = "PROTOBUF"
+)
+
+const (
+	contentType    = "application/vnd.schemaregistry.v1+json"
+	requestTimeout = 10 * time.Second
+	// codice d'errore della registry per un soggetto inesistente e per uno schema non registrato
+	errSubjectNotFound = 40401
+	errSchemaNotFound  = 40403
+)
+
+// ErrNotFound is returned by Lookup when the schema is not registered under the subject.
+var ErrNotFound = errors.New("schema not found")
+
+// Schema è uno schema come lo scambia la registry
+type Schema struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"`
+}
+
+// Client parla con la Schema Registry e mette in cache gli ID degli schemi già risolti
+type Client struct {
+	baseURL string
+	http    *http.Client
+
+	mu  sync.Mutex
+	ids map[cacheKey]int
+}
+
+type cacheKey struct {
+	subject string
+	schema  Schema
+}
+
+// registryError è il corpo delle risposte d'errore della registry
+type registryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func (e *registryError) Error() string {
+	return fmt.Sprintf("schema registry error %d: %s", e.ErrorCode, e.Message)
+}
+
+// NewClient returns a client of the registry at 'baseURL', e.g. constants.SchemaRegistryURL.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: requestTimeout},
+		ids:     make(map[cacheKey]int),
+	}
+}
+
+// ValueSubject returns the subject of the values of 'topic' according to the default TopicNameStrategy.
+func ValueSubject(topic string) string {
+	return topic + "-value"
+}
+
+// Register registers 'schema' under 'subject', or returns the ID of the identical schema already registered.
+func (c *Client) Register(subject string, schema Schema) (int, error) {
+	var response struct {
+		ID int `json:"id"`
+	}
+	if err := c.post("/subjects/"+url.PathEscape(subject)+"/versions", schema, &response); err != nil {
+		return 0, fmt.Errorf("failed to register schema under %s: %w", subject, err)
+	}
+	return response.ID, nil
+}
+
+// Lookup returns the ID of 'schema' if it is registered under 'subject', ErrNotFound otherwise.
+func (c *Client) Lookup(subject string, schema Schema) (int, error) {
+	var response struct {
+		ID int `json:"id"`
+	}
+	err := c.post("/subjects/"+url.PathEscape(subject), schema, &response)
+	if isNotFound(err) {
+		return 0, fmt.Errorf("%w under %s", ErrNotFound, subject)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up schema under %s: %w", subject, err)
+	}
+	return response.ID, nil
+}
+
+// SchemaID returns the ID of 'schema' under 'subject', looking it up in the registry only the first time.
+// With 'autoRegister' a schema not registered yet is registered, otherwise ErrNotFound is returned.
+func (c *Client) SchemaID(subject string, schema Schema, autoRegister bool) (int, error) {
+	key := cacheKey{subject: subject, schema: schema}
+	c.mu.Lock()
+	id, ok := c.ids[key]
+	c.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err := c.Lookup(subject, schema)
+	if errors.Is(err, ErrNotFound) && autoRegister {
+		id, err = c.Register(subject, schema)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	c.ids[key] = id
+	c.mu.Unlock()
+	return id, nil
+}
+
+// Versions returns the versions registered under 'subject', in order, or ErrNotFound if the subject does not exist.
+func (c *Client) Versions(subject string) ([]int, error) {
+	var versions []int
+	err := c.get("/subjects/"+url.PathEscape(subject)+"/versions", &versions)
+	if isNotFound(err) {
+		return nil, fmt.Errorf("%w: subject %s", ErrNotFound, subject)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the versions of %s: %w", subject, err)
+	}
+	return versions, nil
+}
+
+// Version returns the schema registered as 'version' of 'subject'.
+func (c *Client) Version(subject string, version int) (Schema, error) {
+	var schema Schema
+	err := c.get(fmt.Sprintf("/subjects/%s/versions/%d", url.PathEscape(subject), version), &schema)
+	if err != nil {
+		return Schema{}, fmt.Errorf("failed to read version %d of %s: %w", version, subject, err)
+	}
+	return schema, nil
+}
+
+// CheckCompatibility checks the Avro 'schema' against the latest version registered under 'subject', or against
+// every version for a transitive 'level', and returns an *IncompatibleError listing the incompatible fields of each version.
+// A subject without versions, or the NONE level, accepts any schema.
+func (c *Client) CheckCompatibility(subject string, schema Schema, level Compatibility) error {
+	if level == CompatibilityNone {
+		return nil
+	}
+	if schema.SchemaType != TypeAvro {
+		return fmt.Errorf("compatibility check is only supported for Avro schemas, not %s", schema.SchemaType)
+	}
+
+	versions, err := c.Versions(subject)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !level.Transitive() && len(versions) > 0 {
+		versions = versions[len(versions)-1:]
+	}
+
+	incompatible := &IncompatibleError{Subject: subject, Level: level}
+	for _, version := range versions {
+		registered, err := c.Version(subject, version)
+		if err != nil {
+			return err
+		}
+		if registered.SchemaType != TypeAvro {
+			return fmt.Errorf("version %d of %s is a %s schema, not Avro", version, subject, registered.SchemaType)
+		}
+		diffs, err := CheckAvroCompatibility(schema.Schema, registered.Schema, level)
+		if err != nil {
+			return fmt.Errorf("version %d of %s: %w", version, subject, err)
+		}
+		if len(diffs) > 0 {
+			incompatible.Versions = append(incompatible.Versions, VersionDiff{Version: version, Diffs: diffs})
+		}
+	}
+	if len(incompatible.Versions) > 0 {
+		return incompatible
+	}
+	return nil
+}
+
+// IncompatibleError elenca, per ogni versione registrata, i campi che rendono incompatibile il nuovo schema
+type IncompatibleError struct {
+	Subject  string
+	Level    Compatibility
+	Versions []VersionDiff
+}
+
+// VersionDiff sono le differenze incompatibili con una versione registrata
+type VersionDiff struct {
+	Version int
+	Diffs   []string
+}
+
+func (e *IncompatibleError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "schema is not %s compatible with subject %s:", e.Level, e.Subject)
+	for _, version := range e.Versions {
+		fmt.Fprintf(&b, "\n  version %d:", version.Version)
+		for _, diff := range version.Diffs {
+			fmt.Fprintf(&b, "\n    - %s", diff)
+		}
+	}
+	return b.String()
+}
+
+// isNotFound dice se la registry ha risposto che il soggetto o lo schema non esistono
+func isNotFound(err error) bool {
+	var registryErr *registryError
+	return errors.As(err, &registryErr) && (registryErr.ErrorCode == errSubjectNotFound || registryErr.ErrorCode == errSchemaNotFound)
+}
+
+func (c *Client) get(path string, response interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", contentType)
+	return c.do(req, response)
+}
+
+// post invia 'body' in JSON a 'path' e decodifica la risposta in 'response'
+func (c *Client) post(path string, body, response interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", contentType)
+	return c.do(req, response)
+}
+
+func (c *Client) do(req *http.Request, response interface{}) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		registryErr := &registryError{}
+		if json.Unmarshal(data, registryErr) != nil || registryErr.ErrorCode == 0 {
+			registryErr.ErrorCode = resp.StatusCode
+			registryErr.Message = string(bytes.TrimSpace(data))
+		}
+		return registryErr
+	}
+	return json.Unmarshal(data, response)
+}
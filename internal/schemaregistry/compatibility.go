@@ -0,0 +1,354 @@
+package schemaregistry
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Compatibility è la regola con cui un nuovo schema viene confrontato con le versioni registrate
+type Compatibility string
+
+const (
+	CompatibilityNone Compatibility = "NONE"
+	// BACKWARD: i consumer con il nuovo schema leggono i dati scritti con l'ultima versione registrata
+	CompatibilityBackward Compatibility = "BACKWARD"
+	// FORWARD: i consumer con l'ultima versione registrata leggono i dati scritti con il nuovo schema
+	CompatibilityForward Compatibility = "FORWARD"
+	// FULL: BACKWARD e FORWARD insieme
+	CompatibilityFull Compatibility = "FULL"
+	// Le varianti TRANSITIVE applicano la stessa regola a tutte le versioni registrate, non solo all'ultima
+	CompatibilityBackwardTransitive Compatibility = "BACKWARD_TRANSITIVE"
+	CompatibilityForwardTransitive  Compatibility = "FORWARD_TRANSITIVE"
+	CompatibilityFullTransitive     Compatibility = "FULL_TRANSITIVE"
+)
+
+// ParseCompatibility parses a compatibility level, case-insensitive: NONE, BACKWARD, FORWARD, FULL,
+// BACKWARD_TRANSITIVE, FORWARD_TRANSITIVE or FULL_TRANSITIVE.
+func ParseCompatibility(s string) (Compatibility, error) {
+	level := Compatibility(strings.ToUpper(strings.TrimSpace(s)))
+	switch level {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityForward, CompatibilityFull,
+		CompatibilityBackwardTransitive, CompatibilityForwardTransitive, CompatibilityFullTransitive:
+		return level, nil
+	}
+	return "", fmt.Errorf("unknown compatibility %q: expected NONE, BACKWARD, FORWARD, FULL or one of them with the _TRANSITIVE suffix", s)
+}
+
+// Transitive reports whether the level applies to every registered version rather than only the latest one.
+func (c Compatibility) Transitive() bool {
+	return strings.HasSuffix(string(c), transitiveSuffix)
+}
+
+const transitiveSuffix = "_TRANSITIVE"
+
+// rule restituisce la regola senza il suffisso _TRANSITIVE, che riguarda solo le versioni da confrontare
+func (c Compatibility) rule() Compatibility {
+	return Compatibility(strings.TrimSuffix(string(c), transitiveSuffix))
+}
+
+// CheckAvroCompatibility returns the differences that make the Avro schema 'newSchema' incompatible with
+// 'oldSchema' according to 'level', one readable line per field. A transitive level applies the same rule as its
+// non-transitive one. It returns an error if a schema cannot be parsed.
+func CheckAvroCompatibility(newSchema, oldSchema string, level Compatibility) ([]string, error) {
+	level = level.rule()
+	newType, err := parseAvroSchema(newSchema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid new schema: %w", err)
+	}
+	oldType, err := parseAvroSchema(oldSchema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registered schema: %w", err)
+	}
+
+	var diffs []string
+	if level == CompatibilityBackward || level == CompatibilityFull {
+		check := resolution{readerIsNew: true}
+		check.read(newType, oldType, "")
+		diffs = append(diffs, check.diffs...)
+	}
+	if level == CompatibilityForward || level == CompatibilityFull {
+		check := resolution{readerIsNew: false}
+		check.read(oldType, newType, "")
+		diffs = append(diffs, check.diffs...)
+	}
+	return diffs, nil
+}
+
+// avroType è un tipo Avro ridotto a quanto serve per le regole di risoluzione degli schemi
+type avroType struct {
+	kind     string // tipo primitivo, record, enum, array, map, fixed o union
+	name     string // nome dei tipi con nome, senza namespace
+	fields   []avroField
+	symbols  []string
+	fallback string    // simbolo di default dell'enum, letto al posto dei simboli che il reader non conosce
+	items    *avroType // elementi di array e valori di map
+	branches []*avroType
+	size     int
+}
+
+type avroField struct {
+	name       string
+	aliases    []string
+	typ        *avroType
+	hasDefault bool
+}
+
+func (t *avroType) String() string {
+	switch t.kind {
+	case "record", "enum", "fixed":
+		return t.kind + " " + t.name
+	case "array", "map":
+		return t.kind + "<" + t.items.String() + ">"
+	case "union":
+		names := make([]string, len(t.branches))
+		for i, branch := range t.branches {
+			names[i] = branch.String()
+		}
+		return "[" + strings.Join(names, ", ") + "]"
+	}
+	return t.kind
+}
+
+func parseAvroSchema(schema string) (*avroType, error) {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(schema), &raw); err != nil {
+		return nil, err
+	}
+	return parseAvroType(raw, make(map[string]*avroType))
+}
+
+// parseAvroType converte lo schema JSON in avroType; 'named' raccoglie i tipi con nome per i riferimenti
+func parseAvroType(raw interface{}, named map[string]*avroType) (*avroType, error) {
+	switch v := raw.(type) {
+	case string:
+		if t, ok := named[shortName(v)]; ok {
+			return t, nil
+		}
+		return &avroType{kind: v}, nil
+	case []interface{}:
+		union := &avroType{kind: "union"}
+		for _, branch := range v {
+			t, err := parseAvroType(branch, named)
+			if err != nil {
+				return nil, err
+			}
+			union.branches = append(union.branches, t)
+		}
+		return union, nil
+	case map[string]interface{}:
+		return parseComplexType(v, named)
+	}
+	return nil, fmt.Errorf("unsupported type %v", raw)
+}
+
+func parseComplexType(v map[string]interface{}, named map[string]*avroType) (*avroType, error) {
+	kind, ok := v["type"].(string)
+	if !ok {
+		// es. {"type": {"type": "array", ...}}
+		return parseAvroType(v["type"], named)
+	}
+
+	name, _ := v["name"].(string)
+	t := &avroType{kind: kind, name: shortName(name)}
+	switch kind {
+	case "record", "error":
+		t.kind = "record"
+		named[t.name] = t // i campi possono riferirsi al record stesso
+		fields, _ := v["fields"].([]interface{})
+		for _, f := range fields {
+			field, _ := f.(map[string]interface{})
+			fieldName, _ := field["name"].(string)
+			typ, err := parseAvroType(field["type"], named)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", fieldName, err)
+			}
+			_, hasDefault := field["default"]
+			var aliases []string
+			if list, ok := field["aliases"].([]interface{}); ok {
+				for _, alias := range list {
+					if s, ok := alias.(string); ok {
+						aliases = append(aliases, s)
+					}
+				}
+			}
+			t.fields = append(t.fields, avroField{name: fieldName, aliases: aliases, typ: typ, hasDefault: hasDefault})
+		}
+	case "enum":
+		named[t.name] = t
+		symbols, _ := v["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			if s, ok := symbol.(string); ok {
+				t.symbols = append(t.symbols, s)
+			}
+		}
+		t.fallback, _ = v["default"].(string)
+	case "fixed":
+		named[t.name] = t
+		size, _ := v["size"].(float64)
+		t.size = int(size)
+	case "array", "map":
+		key := "items"
+		if kind == "map" {
+			key = "values"
+		}
+		items, err := parseAvroType(v[key], named)
+		if err != nil {
+			return nil, err
+		}
+		t.items = items
+	default:
+		// primitivo, eventualmente con logicalType: conta il tipo sottostante
+		t.name = ""
+	}
+	return t, nil
+}
+
+func shortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// resolution applica le regole di risoluzione Avro tra lo schema del consumer (reader)
+// e quello con cui i dati sono stati scritti (writer), raccogliendo le differenze incompatibili
+type resolution struct {
+	readerIsNew bool // BACKWARD: il reader è il nuovo schema; FORWARD: è la versione registrata
+	diffs       []string
+}
+
+func (r *resolution) addf(path, format string, args ...interface{}) {
+	if path == "" {
+		path = "<root>"
+	}
+	r.diffs = append(r.diffs, path+": "+fmt.Sprintf(format, args...))
+}
+
+// typeChange descrive il cambio di tipo sempre dal vecchio al nuovo schema
+func (r *resolution) typeChange(reader, writer *avroType) string {
+	oldType, newType := writer, reader
+	if !r.readerIsNew {
+		oldType, newType = reader, writer
+	}
+	return fmt.Sprintf("type changed from %s to %s", oldType, newType)
+}
+
+func (r *resolution) read(reader, writer *avroType, path string) {
+	if writer.kind == "union" {
+		// Ogni ramo scritto deve essere leggibile, poi ne vengono confrontati i campi
+		for _, branch := range writer.branches {
+			if !readable(reader, branch) {
+				r.addf(path, "%s", r.typeChange(reader, writer))
+				return
+			}
+		}
+		for _, branch := range writer.branches {
+			r.read(reader, branch, path)
+		}
+		return
+	}
+	if reader.kind == "union" {
+		for _, branch := range reader.branches {
+			if readable(branch, writer) {
+				r.read(branch, writer, path)
+				return
+			}
+		}
+		r.addf(path, "%s", r.typeChange(reader, writer))
+		return
+	}
+
+	if reader.kind != writer.kind && !promotable(writer.kind, reader.kind) {
+		r.addf(path, "%s", r.typeChange(reader, writer))
+		return
+	}
+	switch reader.kind {
+	case "record":
+		r.readRecord(reader, writer, path)
+	case "enum":
+		if reader.fallback != "" {
+			break // i simboli sconosciuti al reader vengono letti come il suo default
+		}
+		for _, symbol := range writer.symbols {
+			if !slices.Contains(reader.symbols, symbol) {
+				r.addf(path, "enum symbol %s %s", symbol, r.verb("removed", "added"))
+			}
+		}
+	case "fixed":
+		if reader.size != writer.size {
+			r.addf(path, "%s", r.typeChange(reader, writer))
+		}
+	case "array", "map":
+		r.read(reader.items, writer.items, path+"[]")
+	}
+}
+
+// verb sceglie il verbo secondo la direzione: 'backward' se il reader è il nuovo schema
+func (r *resolution) verb(backward, forward string) string {
+	if r.readerIsNew {
+		return backward
+	}
+	return forward
+}
+
+func (r *resolution) readRecord(reader, writer *avroType, path string) {
+	prefix := path
+	if prefix != "" {
+		prefix += "."
+	}
+	for _, field := range reader.fields {
+		writerField := findField(writer, field)
+		if writerField == nil {
+			if !field.hasDefault {
+				// BACKWARD: campo aggiunto senza default; FORWARD: campo rimosso che la vecchia versione richiede
+				r.addf(prefix+field.name, "%s", r.verb("field added without a default",
+					"field removed, and the registered version has no default for it"))
+			}
+			continue
+		}
+		r.read(field.typ, writerField.typ, prefix+field.name)
+	}
+}
+
+// findField cerca nel writer il campo del reader per nome o per alias
+func findField(writer *avroType, field avroField) *avroField {
+	for i, candidate := range writer.fields {
+		if candidate.name == field.name || slices.Contains(field.aliases, candidate.name) {
+			return &writer.fields[i]
+		}
+	}
+	return nil
+}
+
+// readable dice se un valore scritto con 'writer' può essere letto con 'reader', senza entrare nei campi dei record
+func readable(reader, writer *avroType) bool {
+	if reader.kind == "union" {
+		return slices.ContainsFunc(reader.branches, func(b *avroType) bool { return readable(b, writer) })
+	}
+	if reader.kind != writer.kind {
+		return promotable(writer.kind, reader.kind)
+	}
+	switch reader.kind {
+	case "record", "enum", "fixed":
+		return reader.name == writer.name
+	case "array", "map":
+		return readable(reader.items, writer.items)
+	}
+	return true
+}
+
+// promotable elenca le promozioni ammesse da Avro tra tipi primitivi, dal tipo scritto a quello letto
+func promotable(writer, reader string) bool {
+	switch writer {
+	case "int":
+		return reader == "long" || reader == "float" || reader == "double"
+	case "long":
+		return reader == "float" || reader == "double"
+	case "float":
+		return reader == "double"
+	case "string":
+		return reader == "bytes"
+	case "bytes":
+		return reader == "string"
+	}
+	return false
+}
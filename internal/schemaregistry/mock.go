@@ -0,0 +1,161 @@
+package schemaregistry
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// MockRegistry è una Schema Registry in memoria su un server HTTP locale, per i test senza docker-compose.
+// Implementa solo le chiamate usate da Client. Non usa httptest per non collegare il package testing ai binari.
+type MockRegistry struct {
+	server   *http.Server
+	url      string
+	requests atomic.Int64
+
+	mu       sync.Mutex
+	schemas  []Schema         // schemi registrati, l'ID è la posizione più uno
+	subjects map[string][]int // ID delle versioni di ogni soggetto, in ordine
+}
+
+// NewMockRegistry starts an empty in-memory registry. Close it when done.
+func NewMockRegistry() *MockRegistry {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("schemaregistry: failed to listen on a local port: " + err.Error())
+	}
+	m := &MockRegistry{subjects: make(map[string][]int), url: "http://" + listener.Addr().String()}
+	m.server = &http.Server{Handler: http.HandlerFunc(m.handle)}
+	go m.server.Serve(listener)
+	return m
+}
+
+// URL returns the base URL of the registry, to pass to NewClient.
+func (m *MockRegistry) URL() string {
+	return m.url
+}
+
+// Requests returns the number of requests served so far.
+func (m *MockRegistry) Requests() int64 {
+	return m.requests.Load()
+}
+
+// Close stops the registry.
+func (m *MockRegistry) Close() {
+	m.server.Close()
+}
+
+func (m *MockRegistry) handle(w http.ResponseWriter, r *http.Request) {
+	m.requests.Add(1)
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(path) < 2 || path[0] != "subjects" {
+		writeJSON(w, http.StatusNotFound, registryError{ErrorCode: http.StatusNotFound, Message: "HTTP 404 Not Found"})
+		return
+	}
+	subject := path[1]
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r.Method == http.MethodGet {
+		m.get(w, subject, path[2:])
+		return
+	}
+
+	var schema Schema
+	if err := json.NewDecoder(r.Body).Decode(&schema); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, registryError{ErrorCode: 42201, Message: "Invalid schema"})
+		return
+	}
+	switch {
+	case len(path) == 3 && path[2] == "versions":
+		id := m.register(subject, schema)
+		writeJSON(w, http.StatusOK, map[string]int{"id": id})
+	case len(path) == 2:
+		versions, ok := m.subjects[subject]
+		if !ok {
+			writeSubjectNotFound(w, subject)
+			return
+		}
+		for i, id := range versions {
+			if m.schemas[id-1] == schema {
+				writeJSON(w, http.StatusOK, map[string]interface{}{"subject": subject, "id": id, "version": i + 1, "schema": schema.Schema})
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, registryError{ErrorCode: errSchemaNotFound, Message: "Schema not found"})
+	default:
+		writeJSON(w, http.StatusNotFound, registryError{ErrorCode: http.StatusNotFound, Message: "HTTP 404 Not Found"})
+	}
+}
+
+// get risponde a GET /subjects/{subject}/versions e GET /subjects/{subject}/versions/{version}
+func (m *MockRegistry) get(w http.ResponseWriter, subject string, path []string) {
+	ids, ok := m.subjects[subject]
+	if !ok {
+		writeSubjectNotFound(w, subject)
+		return
+	}
+	if len(path) == 0 || path[0] != "versions" || len(path) > 2 {
+		writeJSON(w, http.StatusNotFound, registryError{ErrorCode: http.StatusNotFound, Message: "HTTP 404 Not Found"})
+		return
+	}
+
+	if len(path) == 1 {
+		versions := make([]int, len(ids))
+		for i := range ids {
+			versions[i] = i + 1
+		}
+		writeJSON(w, http.StatusOK, versions)
+		return
+	}
+	version, err := strconv.Atoi(path[1])
+	if path[1] == "latest" {
+		version, err = len(ids), nil
+	}
+	if err != nil || version < 1 || version > len(ids) {
+		writeJSON(w, http.StatusNotFound, registryError{ErrorCode: 40402, Message: "Version not found."})
+		return
+	}
+	id := ids[version-1]
+	schema := m.schemas[id-1]
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"subject": subject, "id": id, "version": version, "schema": schema.Schema, "schemaType": schema.SchemaType,
+	})
+}
+
+func writeSubjectNotFound(w http.ResponseWriter, subject string) {
+	writeJSON(w, http.StatusNotFound, registryError{ErrorCode: errSubjectNotFound, Message: "Subject '" + subject + "' not found."})
+}
+
+// register aggiunge lo schema come nuova versione del soggetto, riusando l'ID di uno schema identico
+func (m *MockRegistry) register(subject string, schema Schema) int {
+	id := 0
+	for i, registered := range m.schemas {
+		if registered == schema {
+			id = i + 1
+			break
+		}
+	}
+	if id == 0 {
+		m.schemas = append(m.schemas, schema)
+		id = len(m.schemas)
+	}
+
+	for _, version := range m.subjects[subject] {
+		if version == id {
+			return id
+		}
+	}
+	m.subjects[subject] = append(m.subjects[subject], id)
+	return id
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,245 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const userSchema = `{"type":"record","name":"User","fields":[{"name":"ID","type":"int"}]}`
+
+func TestClient(t *testing.T) {
+	registry := NewMockRegistry()
+	defer registry.Close()
+	client := NewClient(registry.URL())
+	schema := Schema{Schema: userSchema}
+
+	if _, err := client.Lookup("users-value", schema); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Atteso ErrNotFound per un soggetto inesistente, ottenuto: %v", err)
+	}
+	if _, err := client.SchemaID("users-value", schema, false); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Atteso ErrNotFound senza registrazione automatica, ottenuto: %v", err)
+	}
+
+	id, err := client.SchemaID("users-value", schema, true)
+	if err != nil || id != 1 {
+		t.Fatalf("Atteso ID 1 dopo la registrazione, ottenuti: %d, %v", id, err)
+	}
+	// La seconda richiesta dello stesso schema è servita dalla cache
+	requests := registry.Requests()
+	if id, err := client.SchemaID("users-value", schema, true); err != nil || id != 1 {
+		t.Errorf("Atteso ID 1 dalla cache, ottenuti: %d, %v", id, err)
+	}
+	if registry.Requests() != requests {
+		t.Errorf("Attesa nessuna richiesta alla registry per uno schema in cache, ottenute: %d", registry.Requests()-requests)
+	}
+
+	// Un nuovo client trova lo schema già registrato
+	if id, err := NewClient(registry.URL()).Lookup("users-value", schema); err != nil || id != 1 {
+		t.Errorf("Atteso ID 1 dal lookup, ottenuti: %d, %v", id, err)
+	}
+	// Uno schema diverso ottiene un nuovo ID, lo stesso schema sotto un altro soggetto mantiene il suo
+	other := Schema{Schema: strings.Replace(userSchema, `"int"`, `"long"`, 1)}
+	if id, err := client.Register("users-value", other); err != nil || id != 2 {
+		t.Errorf("Atteso ID 2 per il nuovo schema, ottenuti: %d, %v", id, err)
+	}
+	if id, err := client.Register("others-value", schema); err != nil || id != 1 {
+		t.Errorf("Atteso ID 1 per lo schema registrato sotto un altro soggetto, ottenuti: %d, %v", id, err)
+	}
+}
+
+func TestClientError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "registry down", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL).SchemaID("users-value", Schema{Schema: userSchema}, true)
+	if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "registry down") {
+		t.Errorf("Atteso errore della registry con il messaggio del server, ottenuto: %v", err)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	framed := Frame(258, []byte("avro"))
+	if !bytes.Equal(framed, []byte{0, 0, 0, 1, 2, 'a', 'v', 'r', 'o'}) {
+		t.Fatalf("Wire format inatteso: %v", framed)
+	}
+
+	id, payload, err := Unframe(framed)
+	if err != nil || id != 258 || string(payload) != "avro" {
+		t.Errorf("Attesi ID 258 e payload avro, ottenuti: %d, %q, %v", id, payload, err)
+	}
+	if _, _, err := Unframe([]byte{1, 0, 0, 0, 1}); err == nil {
+		t.Error("Atteso errore per un magic byte sconosciuto, ma non si è verificato")
+	}
+	if _, _, err := Unframe([]byte{0, 0}); err == nil {
+		t.Error("Atteso errore per un payload troppo corto, ma non si è verificato")
+	}
+}
+
+const (
+	userV1 = `{"type":"record","name":"User","namespace":"csvreader","fields":[
+		{"name":"ID","type":"int"},{"name":"NomeUtente","type":"string"},{"name":"Email","type":"string"}]}`
+	// Campo nullable aggiunto con default: compatibile in entrambe le direzioni
+	userWithPhone = `{"type":"record","name":"User","namespace":"csvreader","fields":[
+		{"name":"ID","type":"int"},{"name":"NomeUtente","type":"string"},{"name":"Email","type":"string"},
+		{"name":"Phone","type":["null","string"],"default":null}]}`
+	// Campo aggiunto senza default e Email rimosso
+	userBroken = `{"type":"record","name":"User","namespace":"csvreader","fields":[
+		{"name":"ID","type":"int"},{"name":"NomeUtente","type":"string"},{"name":"Phone","type":"string"}]}`
+	// ID cambiato da int a string
+	userStringID = `{"type":"record","name":"User","namespace":"csvreader","fields":[
+		{"name":"ID","type":"string"},{"name":"NomeUtente","type":"string"},{"name":"Email","type":"string"}]}`
+	// ID promosso da int a long: i nuovi consumer leggono i vecchi dati, non il contrario
+	userLongID = `{"type":"record","name":"User","namespace":"csvreader","fields":[
+		{"name":"ID","type":"long"},{"name":"NomeUtente","type":"string"},{"name":"Email","type":"string"}]}`
+
+	statusV1 = `{"type":"record","name":"User","fields":[
+		{"name":"Status","type":{"type":"enum","name":"Status","symbols":["ACTIVE","BANNED"]}}]}`
+	// BANNED rimosso: i nuovi consumer non sanno leggerlo...
+	statusRemoved = `{"type":"record","name":"User","fields":[
+		{"name":"Status","type":{"type":"enum","name":"Status","symbols":["ACTIVE"]}}]}`
+	// ...a meno che l'enum non dichiari un default
+	statusRemovedDefault = `{"type":"record","name":"User","fields":[
+		{"name":"Status","type":{"type":"enum","name":"Status","symbols":["ACTIVE"],"default":"ACTIVE"}}]}`
+)
+
+func TestCheckAvroCompatibility(t *testing.T) {
+	tests := []struct {
+		name      string
+		newSchema string
+		level     Compatibility
+		diffs     []string
+	}{
+		{"campo con default, FULL", userWithPhone, CompatibilityFull, nil},
+		{"promozione int-long, BACKWARD", userLongID, CompatibilityBackward, nil},
+		{"promozione int-long, FORWARD", userLongID, CompatibilityForward, []string{"ID: type changed from int to long"}},
+		{"cambio di tipo", userStringID, CompatibilityBackward, []string{"ID: type changed from int to string"}},
+		{"campo senza default, BACKWARD", userBroken, CompatibilityBackward, []string{"Phone: field added without a default"}},
+		{"campo rimosso, FORWARD", userBroken, CompatibilityForward, []string{"Email: field removed, and the registered version has no default for it"}},
+		{"FULL somma le due direzioni", userBroken, CompatibilityFull, []string{
+			"Phone: field added without a default",
+			"Email: field removed, and the registered version has no default for it",
+		}},
+	}
+	for _, test := range tests {
+		diffs, err := CheckAvroCompatibility(test.newSchema, userV1, test.level)
+		if err != nil {
+			t.Fatalf("%s: errore inatteso: %v", test.name, err)
+		}
+		if strings.Join(diffs, "\n") != strings.Join(test.diffs, "\n") {
+			t.Errorf("%s: differenze attese %q, ottenute %q", test.name, test.diffs, diffs)
+		}
+	}
+
+	// Un simbolo rimosso è incompatibile solo se l'enum del reader non ha un default
+	enumTests := []struct {
+		name      string
+		newSchema string
+		diffs     []string
+	}{
+		{"simbolo rimosso", statusRemoved, []string{"Status: enum symbol BANNED removed"}},
+		{"simbolo rimosso con default", statusRemovedDefault, nil},
+	}
+	for _, test := range enumTests {
+		diffs, err := CheckAvroCompatibility(test.newSchema, statusV1, CompatibilityBackward)
+		if err != nil {
+			t.Fatalf("%s: errore inatteso: %v", test.name, err)
+		}
+		if strings.Join(diffs, "\n") != strings.Join(test.diffs, "\n") {
+			t.Errorf("%s: differenze attese %q, ottenute %q", test.name, test.diffs, diffs)
+		}
+	}
+
+	if _, err := ParseCompatibility("sideways"); err == nil {
+		t.Error("Atteso errore per una compatibilità sconosciuta, ma non si è verificato")
+	}
+	if level, err := ParseCompatibility("full"); err != nil || level != CompatibilityFull {
+		t.Errorf("Attesa compatibilità FULL, ottenuti: %v, %v", level, err)
+	}
+	if level, err := ParseCompatibility("backward_transitive"); err != nil || !level.Transitive() {
+		t.Errorf("Attesa compatibilità BACKWARD_TRANSITIVE, ottenuti: %v, %v", level, err)
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	registry := NewMockRegistry()
+	defer registry.Close()
+	client := NewClient(registry.URL())
+
+	// Un soggetto senza versioni accetta qualsiasi schema
+	if err := client.CheckCompatibility("users-value", Schema{Schema: userBroken}, CompatibilityFull); err != nil {
+		t.Fatalf("Errore inatteso per un soggetto senza versioni: %v", err)
+	}
+
+	for _, schema := range []string{userV1, userWithPhone} {
+		if _, err := client.Register("users-value", Schema{Schema: schema}); err != nil {
+			t.Fatalf("Errore durante la registrazione dello schema: %v", err)
+		}
+	}
+	if versions, err := client.Versions("users-value"); err != nil || len(versions) != 2 {
+		t.Fatalf("Attese 2 versioni, ottenute: %v, %v", versions, err)
+	}
+
+	if err := client.CheckCompatibility("users-value", Schema{Schema: userWithPhone}, CompatibilityFull); err != nil {
+		t.Errorf("Errore inatteso per uno schema compatibile: %v", err)
+	}
+
+	// Senza TRANSITIVE conta solo l'ultima versione: userV1 legge i dati della versione 2 ignorando Phone
+	if err := client.CheckCompatibility("users-value", Schema{Schema: userV1}, CompatibilityBackward); err != nil {
+		t.Errorf("Errore inatteso per uno schema compatibile con l'ultima versione: %v", err)
+	}
+	err := client.CheckCompatibility("users-value", Schema{Schema: userBroken}, CompatibilityBackward)
+	var incompatible *IncompatibleError
+	if !errors.As(err, &incompatible) || len(incompatible.Versions) != 1 || incompatible.Versions[0].Version != 2 {
+		t.Fatalf("Atteso IncompatibleError per la sola versione 2, ottenuto: %v", err)
+	}
+
+	// Phone è già nella versione 2, ma senza default è incompatibile con la versione 1;
+	// il tipo diverso lo rende incompatibile anche con la 2
+	err = client.CheckCompatibility("users-value", Schema{Schema: userBroken}, CompatibilityBackwardTransitive)
+	if !errors.As(err, &incompatible) || len(incompatible.Versions) != 2 {
+		t.Fatalf("Atteso IncompatibleError per 2 versioni, ottenuto: %v", err)
+	}
+	expected := `schema is not BACKWARD_TRANSITIVE compatible with subject users-value:
+  version 1:
+    - Phone: field added without a default
+  version 2:
+    - Phone: type changed from [null, string] to string`
+	if err.Error() != expected {
+		t.Errorf("Messaggio atteso:\n%s\nottenuto:\n%s", expected, err)
+	}
+}
+
+func TestFrameProtobuf(t *testing.T) {
+	// Il primo messaggio del file .proto è indicato da un solo byte 0
+	framed := FrameProtobuf(1, []int{0}, []byte("pb"))
+	if !bytes.Equal(framed, []byte{0, 0, 0, 0, 1, 0, 'p', 'b'}) {
+		t.Fatalf("Wire format inatteso: %v", framed)
+	}
+	id, indexes, payload, err := UnframeProtobuf(framed)
+	if err != nil || id != 1 || len(indexes) != 1 || indexes[0] != 0 || string(payload) != "pb" {
+		t.Errorf("Attesi ID 1, indici [0] e payload pb, ottenuti: %d, %v, %q, %v", id, indexes, payload, err)
+	}
+
+	// Un messaggio annidato: numero di indici seguito dagli indici, in varint zig-zag
+	framed = FrameProtobuf(1, []int{1, 2}, []byte("pb"))
+	if !bytes.Equal(framed[5:], []byte{4, 2, 4, 'p', 'b'}) {
+		t.Fatalf("Indici dei messaggi inattesi: %v", framed[5:])
+	}
+	if _, indexes, payload, err := UnframeProtobuf(framed); err != nil || fmt.Sprint(indexes) != "[1 2]" || string(payload) != "pb" {
+		t.Errorf("Attesi indici [1 2] e payload pb, ottenuti: %v, %q, %v", indexes, payload, err)
+	}
+
+	// Un count degli indici contraffatto viene rifiutato invece di allocare (o mandare in panic) un enorme slice
+	forged := binary.AppendVarint(Frame(1, nil), 1<<60)
+	if _, _, _, err := UnframeProtobuf(append(forged, 2, 'p', 'b')); err == nil {
+		t.Error("Atteso errore per un numero di indici contraffatto, ma non si è verificato")
+	}
+}
@@ -0,0 +1,81 @@
+package schemaregistry
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MagicByte è il primo byte di un payload in wire format Confluent
+const MagicByte = 0
+
+// headerSize è la dimensione del prefisso: magic byte più ID dello schema big-endian a 4 byte
+const headerSize = 5
+
+// Frame returns 'payload' in Confluent wire format: the magic byte, the 4-byte big-endian 'schemaID', then the payload.
+func Frame(schemaID int, payload []byte) []byte {
+	framed := make([]byte, headerSize, headerSize+len(payload))
+	framed[0] = MagicByte
+	binary.BigEndian.PutUint32(framed[1:headerSize], uint32(schemaID))
+	return append(framed, payload...)
+}
+
+// Unframe splits a payload in Confluent wire format into the schema ID and the encoded data.
+func Unframe(framed []byte) (int, []byte, error) {
+	if len(framed) < headerSize {
+		return 0, nil, fmt.Errorf("payload of %d bytes too short for the Confluent wire format", len(framed))
+	}
+	if framed[0] != MagicByte {
+		return 0, nil, fmt.Errorf("unknown magic byte %d, expected %d", framed[0], MagicByte)
+	}
+	return int(binary.BigEndian.Uint32(framed[1:headerSize])), framed[headerSize:], nil
+}
+
+// FrameProtobuf returns 'payload' in Confluent wire format for Protobuf: after the magic byte and the schema ID
+// come the indexes of the message in the .proto file (e.g. [0] for its first message), as a count followed by
+// the indexes in zig-zag varints; the common [0] case is written as a single 0 byte.
+func FrameProtobuf(schemaID int, messageIndexes []int, payload []byte) []byte {
+	framed := Frame(schemaID, nil)
+	if len(messageIndexes) == 1 && messageIndexes[0] == 0 {
+		framed = append(framed, 0)
+	} else {
+		framed = binary.AppendVarint(framed, int64(len(messageIndexes)))
+		for _, index := range messageIndexes {
+			framed = binary.AppendVarint(framed, int64(index))
+		}
+	}
+	return append(framed, payload...)
+}
+
+// UnframeProtobuf splits a Protobuf payload in Confluent wire format into the schema ID,
+// the message indexes and the encoded message.
+func UnframeProtobuf(framed []byte) (int, []int, []byte, error) {
+	id, data, err := Unframe(framed)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	count, n := binary.Varint(data)
+	if n <= 0 || count < 0 {
+		return 0, nil, nil, fmt.Errorf("invalid message indexes in the Confluent wire format")
+	}
+	data = data[n:]
+	// Ogni indice occupa almeno un byte: un count più grande del resto del payload è malformato
+	// e non deve arrivare a make, che con un count enorme va in panic
+	if count > int64(len(data)) {
+		return 0, nil, nil, fmt.Errorf("invalid message indexes in the Confluent wire format: %d indexes in %d bytes", count, len(data))
+	}
+	if count == 0 {
+		return id, []int{0}, data, nil
+	}
+
+	indexes := make([]int, 0, count)
+	for i := int64(0); i < count; i++ {
+		index, n := binary.Varint(data)
+		if n <= 0 {
+			return 0, nil, nil, fmt.Errorf("invalid message indexes in the Confluent wire format")
+		}
+		indexes = append(indexes, int(index))
+		data = data[n:]
+	}
+	return id, indexes, data, nil
+}
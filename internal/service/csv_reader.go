@@ -5,28 +5,39 @@ import (
 	"csvreader/pkg/utils"
 )
 
-// GetUsers retrieves users from a CSV file and returns a slice of model.User objects.
-// It reads the CSV file in a separate goroutine and uses channels to handle errors and data.
+// GetUsers retrieves users from the CSV files matching 'input' and returns a slice of model.User objects.
+// It consumes the stream returned by StreamUsers row by row and collects the users into a slice.
+// The 'cfg' parameter sets the separator and the header column mapping used to read the files.
 // If an error occurs during CSV reading, it returns the error.
 // If the CSV reading is successful, it returns the slice of users.
-func GetUsers() ([]models.User, error) {
-	usersChan := make(chan []models.User, 1)
-	errorsChan := make(chan error, 1)
-
-	go func() {
-		users, err := utils.ReadCSV()
-		if err != nil {
-			errorsChan <- err
-			close(usersChan)
-			return
-		}
-		usersChan <- users
-	}()
+func GetUsers(input string, cfg utils.CSVConfig) ([]models.User, error) {
+	usersChan, errorsChan, _, err := StreamUsers(input, cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	select {
-	case err := <-errorsChan:
+	var users []models.User
+	for user := range usersChan {
+		users = append(users, user)
+	}
+	if err := <-errorsChan; err != nil {
 		return nil, err
-	case users := <-usersChan:
-		return users, nil
 	}
+	return users, nil
+}
+
+// StreamUsers reads users from the CSV files matching 'input' and streams them one by one,
+// without loading the files in memory. 'input' can be a file, a glob or a directory (see utils.ResolveInputs):
+// every matching file is ingested in the same stream and each user records its source file.
+// The users channel is closed once all files are read or the run is aborted; the errors, if any,
+// are then available on the errors channel, while the report holds the per-file progress and errors.
+// It returns an error right away if 'input' matches no file.
+func StreamUsers(input string, cfg utils.CSVConfig) (<-chan models.User, <-chan error, *utils.IngestionReport, error) {
+	files, err := utils.ResolveInputs(input, cfg.Format)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	usersChan, errorsChan, report := utils.StreamFiles(files, cfg)
+	return usersChan, errorsChan, report, nil
 }
@@ -3,15 +3,41 @@ package constants
 const (
 	FileOpenErrMessage    = "error during file opening: %v"
 	RecordsReadErrMessage = "error during reading records: %v"
+	HeaderErrMessage      = "invalid CSV header: %v"
 	UsersFile             = "users_million.csv"
 	Separator             = '|'
+	FixedWidthColumns     = "ID:0:10,NomeUtente:10:30,Email:40:50" // layout di default del formato a larghezza fissa
+
+	// quarantena delle righe scartate
+	QuarantineFileName = "resources/files/generated/rejected_rows.jsonl"
+	MaxRejectedRows    = 1000
+
+	// checkpoint per riprendere una run interrotta
+	CheckpointFileName = "resources/files/generated/checkpoint.json"
+
+	// streaming
+	StreamBufferSize = 1024    // utenti bufferizzati tra il lettore CSV e i consumer
+	ReadBufferSize   = 1 << 20 // buffer di lettura del file CSV
+	WriteBufferSize  = 1 << 20 // buffer di scrittura dei file generati
+	ChunkSize        = 8 << 20 // dimensione dei chunk del parsing parallelo
+	ProgressEvery    = 100000  // ogni quanti utenti letti viene loggato l'avanzamento di un file
+	MaxLineSize      = 1 << 20 // lunghezza massima di una riga JSON Lines o a larghezza fissa
 
 	// main kafka
 	KafkaBootstrapServers = "localhost:9092"
 	KafkaTopic            = "oneMillionGO-avro-v0.0.1"
+	KafkaAvroTopic        = "oneMillionGO-avro-v0.0.1-binary" // topic dei messaggi Avro (-wire-format avro o both)
+	KafkaProtobufTopic    = "oneMillionGO-protobuf-v0.0.1"    // topic dei messaggi Protobuf (-wire-format protobuf)
+	TopicPartitions       = 6                                 // partizioni del topic creato con -provision
+	TopicReplication      = 1                                 // replication factor del topic creato con -provision (1 = singolo broker di sviluppo)
+	SchemaRegistryURL     = "http://localhost:8081"
+	SourceFileHeader      = "source-file"
 	NumWorkers            = 3
 	JSONFileName          = "resources/files/generated/users.json"
-	AvroFileName          = "resources/files/generated/avro_users.json"
+	AvroFileName          = "resources/files/generated/avro_users.avro"
+	AvroBlockSize         = 1000 // record per blocco del file Avro
+	AvroSchemaFileName    = "resources/files/generated/user.avsc"
+	SchemaSampleRows      = 1000
 	BatchSize             = 100000
 
 	// logs
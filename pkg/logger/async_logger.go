@@ -18,6 +18,7 @@ var (
 	logCh   chan logMessage
 	closeCh chan struct{}
 	flushCh chan struct{}
+	logFile *os.File
 )
 
 type logMessage struct {
@@ -26,11 +27,8 @@ type logMessage struct {
 }
 
 func init() {
-	file, err := os.OpenFile(constants.LOGFILE, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-	if err != nil {
-		log.Fatalf("Errore nell'apertura del file di log: %v", err)
-	}
-	Async = log.New(io.MultiWriter(os.Stdout, file), constants.PREFIX, log.Ldate|log.Ltime|log.Lmicroseconds)
+	// Fino a Init si scrive solo su stdout: i test e gli strumenti che non chiamano Init non hanno bisogno del file di log
+	Async = log.New(os.Stdout, constants.PREFIX, log.Ldate|log.Ltime|log.Lmicroseconds)
 	logCh = make(chan logMessage, 100)
 	closeCh = make(chan struct{})
 	flushCh = make(chan struct{})
@@ -38,6 +36,18 @@ func init() {
 	go logWorker()
 }
 
+// Init writes the log to the file at 'path' as well as to stdout.
+// It returns an error if the file cannot be opened.
+func Init(path string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("Errore nell'apertura del file di log: %w", err)
+	}
+	logFile = file
+	Async.SetOutput(io.MultiWriter(os.Stdout, file))
+	return nil
+}
+
 func logWorker() {
 	for {
 		select {
@@ -92,4 +102,7 @@ func ErrorAsync(v ...interface{}) {
 func Close() {
 	close(closeCh)
 	<-flushCh
+	if logFile != nil {
+		logFile.Close()
+	}
 }
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"bufio"
+	"csvreader/internal/models"
+	"csvreader/pkg/constants"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// AvroOCFOptions configura i file Avro Object Container scritti da WriteAvroToFile e WriteAvroStreamToFile
+type AvroOCFOptions struct {
+	Compression string // codec dei blocchi: null, deflate o snappy
+	BlockSize   int    // numero di record per blocco
+}
+
+// DefaultAvroOCFOptions returns deflate-compressed blocks of constants.AvroBlockSize records.
+func DefaultAvroOCFOptions() AvroOCFOptions {
+	return AvroOCFOptions{Compression: goavro.CompressionDeflateLabel, BlockSize: constants.AvroBlockSize}
+}
+
+// avroOCFWriter accumula i record e li scrive nel container un blocco alla volta:
+// ogni Append del writer di goavro produce un blocco con il suo sync marker
+type avroOCFWriter struct {
+	ocf       *goavro.OCFWriter
+	encoder   *AvroUserEncoder
+	block     []interface{}
+	blockSize int
+}
+
+// Validate checks the compression codec and the block size.
+func (opts AvroOCFOptions) Validate() error {
+	switch opts.Compression {
+	case goavro.CompressionNullLabel, goavro.CompressionDeflateLabel, goavro.CompressionSnappyLabel:
+	default:
+		return fmt.Errorf("compressione Avro %q non supportata, valori ammessi: null, deflate, snappy", opts.Compression)
+	}
+	if opts.BlockSize <= 0 {
+		return fmt.Errorf("dimensione dei blocchi Avro non valida: %d", opts.BlockSize)
+	}
+	return nil
+}
+
+func newAvroOCFWriter(w io.Writer, encoder *AvroUserEncoder, opts AvroOCFOptions) (*avroOCFWriter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:               w,
+		Codec:           encoder.Codec(),
+		CompressionName: opts.Compression,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("errore nella creazione del file Avro: %v", err)
+	}
+	return &avroOCFWriter{ocf: ocf, encoder: encoder, block: make([]interface{}, 0, opts.BlockSize), blockSize: opts.BlockSize}, nil
+}
+
+func (w *avroOCFWriter) write(user models.User) error {
+	record, err := w.encoder.Native(user)
+	if err != nil {
+		return fmt.Errorf("errore nella codifica Avro: %v", err)
+	}
+	w.block = append(w.block, record)
+	if len(w.block) == w.blockSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush scrive i record accumulati come un blocco
+func (w *avroOCFWriter) flush() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+	if err := w.ocf.Append(w.block); err != nil {
+		return fmt.Errorf("errore durante la scrittura dei dati Avro su file: %v", err)
+	}
+	w.block = w.block[:0]
+	return nil
+}
+
+// WriteAvroToFile writes the users to 'filename' as an Avro Object Container File encoded with 'schema'
+// (UserAvroSchema or a schema loaded with LoadAvroSchema), whose fields are matched to the User fields with
+// 'mapping' (see NewAvroUserEncoder): the schema is embedded in the header and the records are written in blocks
+// compressed according to 'opts', so that any Avro tool can read the file.
+func WriteAvroToFile(users []models.User, filename, schema string, mapping ColumnMapping, opts AvroOCFOptions) error {
+	stream := make(chan models.User)
+	go func() {
+		defer close(stream)
+		for _, user := range users {
+			stream <- user
+		}
+	}()
+	return WriteAvroStreamToFile(stream, filename, schema, mapping, opts)
+}
+
+// ReadAvroFile reads back the users of an Avro Object Container File, e.g. written by WriteAvroToFile,
+// using the schema embedded in the file and 'mapping' to match its fields to the User fields.
+func ReadAvroFile(filename string, mapping ColumnMapping) ([]models.User, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("errore durante la lettura del file Avro %s: %v", filename, err)
+	}
+	defer safelyClose(file)
+
+	var users []models.User
+	err = ReadAvroOCF(file, mapping, func(user models.User) {
+		users = append(users, user)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("errore durante la lettura del file Avro %s: %v", filename, err)
+	}
+	return users, nil
+}
+
+// ReadAvroOCF decodes the records of the Avro Object Container File in 'r' and emits them as users,
+// matching the schema fields to the User fields with 'mapping' like AvroUserEncoder.
+func ReadAvroOCF(r io.Reader, mapping ColumnMapping, emit func(models.User)) error {
+	ocf, err := goavro.NewOCFReader(bufio.NewReaderSize(r, constants.ReadBufferSize))
+	if err != nil {
+		return err
+	}
+	encoder, err := NewAvroUserEncoder(ocf.Codec().Schema(), mapping)
+	if err != nil {
+		return err
+	}
+
+	for ocf.Scan() {
+		native, err := ocf.Read()
+		if err != nil {
+			return err
+		}
+		user, err := encoder.Decode(native)
+		if err != nil {
+			return err
+		}
+		emit(user)
+	}
+	return ocf.Err()
+}
+
+// Decode converts a record decoded by goavro with the encoder's schema back into a user.
+func (e *AvroUserEncoder) Decode(native interface{}) (models.User, error) {
+	record, ok := native.(map[string]interface{})
+	if !ok {
+		return models.User{}, fmt.Errorf("record Avro atteso, trovato %T", native)
+	}
+
+	var user models.User
+	for _, field := range e.fields {
+		value := record[field.name]
+		if union, ok := value.(map[string]interface{}); ok {
+			value = union[field.primitive] // union con null: {"tipo": valore}
+		}
+		if field.userField == "" || value == nil {
+			continue
+		}
+
+		switch field.userField {
+		case FieldID:
+			id, err := avroInteger(value)
+			if err != nil {
+				return models.User{}, fmt.Errorf("campo %s: %v", field.name, err)
+			}
+			user.ID = id
+		case FieldNomeUtente:
+			user.NomeUtente = fmt.Sprint(value)
+		case FieldEmail:
+			user.Email = fmt.Sprint(value)
+		}
+	}
+	return user, nil
+}
+
+// avroInteger converte un valore nativo di goavro nell'id di User
+func avroInteger(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	case float64:
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	}
+	return 0, fmt.Errorf("impossibile convertire %T in un intero", value)
+}
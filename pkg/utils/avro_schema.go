@@ -0,0 +1,334 @@
+package utils
+
+import (
+	"csvreader/internal/models"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// Tipi primitivi Avro riconosciuti dall'inferenza e dall'encoder
+const (
+	avroNull    = "null"
+	avroBoolean = "boolean"
+	avroInt     = "int"
+	avroLong    = "long"
+	avroDouble  = "double"
+	avroString  = "string"
+)
+
+// LoadAvroSchema reads an Avro schema from a .avsc file and checks that it can be compiled by goavro.
+func LoadAvroSchema(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("errore durante la lettura dello schema Avro %s: %v", path, err)
+	}
+	if _, err := goavro.NewCodec(string(data)); err != nil {
+		return "", fmt.Errorf("schema Avro %s non valido: %v", path, err)
+	}
+	return string(data), nil
+}
+
+// avroSchemaField è un campo di uno schema Avro di tipo record, con il tipo ridotto a un primitivo
+type avroSchemaField struct {
+	name       string
+	userField  string // campo di models.User associato, vuoto se nessuno
+	primitive  string // tipo primitivo (per le union, il tipo non null)
+	nullable   bool   // union con null
+	hasDefault bool   // il campo ha un valore di default nello schema
+}
+
+// AvroUserEncoder converte models.User in record Avro binari secondo uno schema qualsiasi,
+// associando i campi dello schema a quelli di User per nome con la stessa ColumnMapping del CSV
+// (es. "ID", "id" oppure "user_id" se mappato). I campi dello schema senza un campo di User
+// associato devono essere nullable o avere un default.
+type AvroUserEncoder struct {
+	codec  *goavro.Codec
+	fields []avroSchemaField
+}
+
+// NewAvroUserEncoder compiles 'schema' and resolves its fields against models.User using 'mapping'
+// (DefaultColumnMapping if nil). A field matches a mapped column by the column's sanitized Avro name,
+// as written by InferAvroSchema (e.g. the column "user id" matches the field user_id). It returns an error if the schema is not a record of primitive fields
+// or if a required field cannot be filled from a User.
+func NewAvroUserEncoder(schema string, mapping ColumnMapping) (*AvroUserEncoder, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, fmt.Errorf("errore nella creazione del codec Avro: %v", err)
+	}
+	if mapping == nil {
+		mapping = DefaultColumnMapping()
+	}
+	// Nello schema inferito i campi hanno il nome della colonna reso valido per Avro (vedi avroName)
+	userFields := make(map[string]string, len(mapping))
+	for column, field := range mapping {
+		userFields[normalizeColumn(avroName(column))] = field
+	}
+
+	var record struct {
+		Type   string `json:"type"`
+		Fields []struct {
+			Name    string          `json:"name"`
+			Type    json.RawMessage `json:"type"`
+			Default json.RawMessage `json:"default"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(schema), &record); err != nil || record.Type != "record" {
+		return nil, fmt.Errorf("lo schema Avro deve essere un record")
+	}
+
+	encoder := &AvroUserEncoder{codec: codec}
+	for _, f := range record.Fields {
+		primitive, nullable, err := primitiveAvroType(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("campo %s dello schema Avro: %v", f.Name, err)
+		}
+
+		field := avroSchemaField{
+			name:       f.Name,
+			userField:  userFields[normalizeColumn(f.Name)],
+			primitive:  primitive,
+			nullable:   nullable,
+			hasDefault: len(f.Default) > 0,
+		}
+		if field.userField == "" && !field.nullable && !field.hasDefault {
+			return nil, fmt.Errorf("campo %s dello schema Avro non associato a nessun campo di User e senza default", f.Name)
+		}
+		encoder.fields = append(encoder.fields, field)
+	}
+	return encoder, nil
+}
+
+// Codec returns the compiled goavro codec of the schema.
+func (e *AvroUserEncoder) Codec() *goavro.Codec {
+	return e.codec
+}
+
+// Native converts the user into the native map expected by goavro for the schema.
+func (e *AvroUserEncoder) Native(user models.User) (map[string]interface{}, error) {
+	record := make(map[string]interface{}, len(e.fields))
+	for _, field := range e.fields {
+		if field.userField == "" {
+			if field.nullable && !field.hasDefault {
+				record[field.name] = nil
+			}
+			continue // goavro usa il default dello schema
+		}
+
+		value, err := avroValue(userFieldValue(user, field.userField), field.primitive)
+		if err != nil {
+			return nil, fmt.Errorf("campo %s: %v", field.name, err)
+		}
+		if field.nullable {
+			value = goavro.Union(field.primitive, value)
+		}
+		record[field.name] = value
+	}
+	return record, nil
+}
+
+// Encode appends the binary Avro encoding of the user to 'buf' and returns the extended buffer.
+func (e *AvroUserEncoder) Encode(buf []byte, user models.User) ([]byte, error) {
+	record, err := e.Native(user)
+	if err != nil {
+		return nil, fmt.Errorf("errore nella codifica Avro: %v", err)
+	}
+	binary, err := e.codec.BinaryFromNative(buf, record)
+	if err != nil {
+		return nil, fmt.Errorf("errore nella codifica Avro: %v", err)
+	}
+	return binary, nil
+}
+
+// primitiveAvroType riduce il tipo di un campo a un primitivo, accettando anche le union con null
+func primitiveAvroType(raw json.RawMessage) (string, bool, error) {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		return name, false, nil
+	}
+
+	var union []string
+	if err := json.Unmarshal(raw, &union); err == nil {
+		var types []string
+		nullable := false
+		for _, t := range union {
+			if t == avroNull {
+				nullable = true
+				continue
+			}
+			types = append(types, t)
+		}
+		if len(types) == 1 {
+			return types[0], nullable, nil
+		}
+		return "", false, fmt.Errorf("sono supportate solo union di un tipo con null, trovato %s", raw)
+	}
+
+	var complex struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &complex); err == nil && complex.Type != "" {
+		return complex.Type, false, nil // es. tipi con logicalType
+	}
+	return "", false, fmt.Errorf("tipo %s non supportato", raw)
+}
+
+// userFieldValue restituisce il valore di un campo di models.User
+func userFieldValue(user models.User, field string) interface{} {
+	switch field {
+	case FieldID:
+		return user.ID
+	case FieldNomeUtente:
+		return user.NomeUtente
+	default:
+		return user.Email
+	}
+}
+
+// avroValue converte il valore di un campo di User nel tipo nativo Go atteso da goavro per il primitivo
+func avroValue(value interface{}, primitive string) (interface{}, error) {
+	switch v := value.(type) {
+	case int:
+		switch primitive {
+		case avroInt, avroLong:
+			return int64(v), nil
+		case avroDouble:
+			return float64(v), nil
+		case avroString:
+			return strconv.Itoa(v), nil
+		}
+	case string:
+		switch primitive {
+		case avroString:
+			return v, nil
+		case avroInt, avroLong:
+			return strconv.ParseInt(v, 10, 64)
+		case avroDouble:
+			return strconv.ParseFloat(v, 64)
+		case avroBoolean:
+			return strconv.ParseBool(v)
+		}
+	}
+	return nil, fmt.Errorf("impossibile convertire %T nel tipo Avro %s", value, primitive)
+}
+
+// avroNameRegexp descrive i nomi ammessi da Avro per record e campi
+var avroNameRegexp = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// InferAvroSchema reads the header and up to 'sampleRows' records of the CSV in 'r' and infers an Avro record
+// schema named 'recordName' (optionally in 'namespace'). For every column the narrowest type that fits all the
+// sampled values is chosen among boolean, int, long, double and string; a column with empty values becomes
+// a union with null and a null default. Column names are sanitized into valid Avro names.
+func InferAvroSchema(r io.Reader, cfg CSVConfig, sampleRows int, recordName, namespace string) (string, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = cfg.Separator
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return "", fmt.Errorf("errore durante la lettura dell'intestazione: %v", err)
+	}
+
+	types := make([]string, len(header))
+	nullable := make([]bool, len(header))
+	for rows := 0; sampleRows <= 0 || rows < sampleRows; rows++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("errore durante la lettura del campione: %v", err)
+		}
+
+		for i := range header {
+			if i >= len(record) || strings.TrimSpace(record[i]) == "" {
+				nullable[i] = true
+				continue
+			}
+			types[i] = widenAvroType(types[i], valueAvroType(strings.TrimSpace(record[i])))
+		}
+	}
+
+	type schemaField struct {
+		Name    string      `json:"name"`
+		Type    interface{} `json:"type"`
+		Default interface{} `json:"default,omitempty"`
+	}
+	type schemaRecord struct {
+		Type      string        `json:"type"`
+		Name      string        `json:"name"`
+		Namespace string        `json:"namespace,omitempty"`
+		Fields    []schemaField `json:"fields"`
+	}
+
+	schema := schemaRecord{Type: "record", Name: avroName(recordName), Namespace: namespace}
+	for i, column := range header {
+		fieldType := types[i]
+		if fieldType == "" {
+			fieldType = avroString // colonna sempre vuota nel campione
+		}
+		field := schemaField{Name: avroName(column), Type: fieldType}
+		if nullable[i] {
+			field.Type = []string{avroNull, fieldType}
+			field.Default = json.RawMessage("null")
+		}
+		schema.Fields = append(schema.Fields, field)
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("errore durante la conversione in JSON dello schema: %v", err)
+	}
+	if _, err := goavro.NewCodec(string(data)); err != nil {
+		return "", fmt.Errorf("schema Avro inferito non valido: %v", err)
+	}
+	return string(data), nil
+}
+
+// valueAvroType restituisce il tipo più stretto che rappresenta il valore
+func valueAvroType(value string) string {
+	if _, err := strconv.ParseInt(value, 10, 32); err == nil {
+		return avroInt
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return avroLong
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return avroDouble
+	}
+	if strings.EqualFold(value, "true") || strings.EqualFold(value, "false") {
+		return avroBoolean
+	}
+	return avroString
+}
+
+// widenAvroType restituisce il tipo che rappresenta valori di entrambi i tipi
+func widenAvroType(current, next string) string {
+	if current == "" || current == next {
+		return next
+	}
+	rank := map[string]int{avroInt: 1, avroLong: 2, avroDouble: 3}
+	if rank[current] > 0 && rank[next] > 0 {
+		if rank[current] > rank[next] {
+			return current
+		}
+		return next
+	}
+	return avroString // es. boolean e numeri, oppure stringhe
+}
+
+// avroName rende il nome valido per Avro: solo lettere, cifre e underscore, senza cifra iniziale
+func avroName(name string) string {
+	name = avroNameRegexp.ReplaceAllString(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
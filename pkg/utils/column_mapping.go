@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"csvreader/internal/models"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Campi di models.User che possono essere associati a una colonna del CSV
+const (
+	FieldID         = "ID"
+	FieldNomeUtente = "NomeUtente"
+	FieldEmail      = "Email"
+)
+
+// requiredFields elenca, nell'ordine posizionale storico del CSV, i campi che devono essere presenti nell'intestazione
+var requiredFields = []string{FieldID, FieldNomeUtente, FieldEmail}
+
+// ColumnMapping associa il nome di una colonna dell'intestazione (es. "user_name") al campo di models.User (es. "NomeUtente").
+// I nomi delle colonne vengono confrontati senza distinzione tra maiuscole e minuscole e ignorando gli spazi.
+type ColumnMapping map[string]string
+
+// DefaultColumnMapping returns the mapping used when nothing is configured:
+// every field of models.User is matched both by its Go name and by its json tag.
+func DefaultColumnMapping() ColumnMapping {
+	return ColumnMapping{
+		"id":          FieldID,
+		"nomeutente":  FieldNomeUtente,
+		"nome_utente": FieldNomeUtente,
+		"email":       FieldEmail,
+	}
+}
+
+// ParseColumnMapping parses a mapping written as "column=Field" pairs separated by commas,
+// for example "user_name=NomeUtente,mail=Email". The parsed pairs are added on top of
+// DefaultColumnMapping, so only the renamed columns need to be listed.
+// It returns an error if a pair is malformed or refers to an unknown models.User field.
+func ParseColumnMapping(s string) (ColumnMapping, error) {
+	mapping := DefaultColumnMapping()
+	if strings.TrimSpace(s) == "" {
+		return mapping, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		column, field, ok := strings.Cut(pair, "=")
+		column, field = normalizeColumn(column), strings.TrimSpace(field)
+		if !ok || column == "" || field == "" {
+			return nil, fmt.Errorf("mappatura colonna non valida %q: atteso formato colonna=Campo", pair)
+		}
+		if !isUserField(field) {
+			return nil, fmt.Errorf("mappatura colonna %q: campo %q sconosciuto, campi ammessi: %s",
+				column, field, strings.Join(requiredFields, ", "))
+		}
+		mapping[column] = field
+	}
+
+	return mapping, nil
+}
+
+// columnIndex contiene la posizione nel record di ciascun campo di models.User
+type columnIndex struct {
+	id         int
+	nomeUtente int
+	email      int
+	minLen     int // lunghezza minima che un record deve avere per contenere tutti i campi
+}
+
+// positionalColumnIndex è l'indice storico: ID, NomeUtente ed Email nelle prime tre colonne
+var positionalColumnIndex = columnIndex{id: 0, nomeUtente: 1, email: 2, minLen: 3}
+
+// newColumnIndex builds the column index from the header row using the given mapping.
+// Columns that are not in the mapping are ignored. It fails fast if a required field has no column
+// or if two columns are mapped to the same field, listing the header that was found.
+func newColumnIndex(header []string, mapping ColumnMapping) (columnIndex, error) {
+	positions := make(map[string]int, len(requiredFields))
+	for i, column := range header {
+		field, ok := mapping[normalizeColumn(column)]
+		if !ok {
+			continue
+		}
+		if previous, dup := positions[field]; dup {
+			return columnIndex{}, fmt.Errorf("le colonne %q e %q sono entrambe associate al campo %s",
+				header[previous], column, field)
+		}
+		positions[field] = i
+	}
+
+	var missing []string
+	for _, field := range requiredFields {
+		if _, ok := positions[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return columnIndex{}, fmt.Errorf("colonne obbligatorie mancanti nell'intestazione per i campi %s (intestazione: %s)",
+			strings.Join(missing, ", "), strings.Join(header, ", "))
+	}
+
+	idx := columnIndex{id: positions[FieldID], nomeUtente: positions[FieldNomeUtente], email: positions[FieldEmail]}
+	idx.minLen = max(idx.id, idx.nomeUtente, idx.email) + 1
+	return idx, nil
+}
+
+// createUser creates a models.User object from the given CSV record, reading each field from its mapped column.
+// If the record is too short or the ID is not a number, it returns an error.
+func (c columnIndex) createUser(record []string) (models.User, error) {
+	if len(record) < c.minLen {
+		return models.User{}, fmt.Errorf("record con %d colonne, attese almeno %d", len(record), c.minLen)
+	}
+
+	id, err := strconv.Atoi(record[c.id])
+	if err != nil {
+		return models.User{}, fmt.Errorf("errore durante la conversione dell'id: %v", err)
+	}
+
+	return models.User{
+		ID:         id,
+		NomeUtente: record[c.nomeUtente],
+		Email:      record[c.email],
+	}, nil
+}
+
+func normalizeColumn(column string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))) // BOM eventualmente presente nella prima colonna
+}
+
+func isUserField(field string) bool {
+	for _, f := range requiredFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
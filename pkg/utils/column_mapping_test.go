@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"csvreader/internal/models"
+	"testing"
+)
+
+func TestColumnIndexFromHeader(t *testing.T) {
+	mapping, err := ParseColumnMapping("user_name=NomeUtente, mail=Email")
+	if err != nil {
+		t.Fatalf("Errore durante il parsing della mappatura: %v", err)
+	}
+
+	// Colonne in ordine diverso da quello storico, con una colonna in più da ignorare
+	index, err := newColumnIndex([]string{"mail", "extra", "ID", "user_name"}, mapping)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione dell'indice: %v", err)
+	}
+	user, err := index.createUser([]string{"user1@example.com", "x", "1", "user1"})
+	if err != nil {
+		t.Fatalf("Errore durante la creazione dell'utente dal record: %v", err)
+	}
+	expectedUser := models.User{ID: 1, NomeUtente: "user1", Email: "user1@example.com"}
+	if user != expectedUser {
+		t.Errorf("Utente atteso: %v, ottenuto: %v", expectedUser, user)
+	}
+
+	// Record troppo corto: errore invece di panic
+	if _, err := index.createUser([]string{"user1@example.com"}); err == nil {
+		t.Errorf("Atteso errore per un record troppo corto, ma non si è verificato")
+	}
+
+	// Colonna obbligatoria mancante
+	if _, err := newColumnIndex([]string{"id", "user_name"}, mapping); err == nil {
+		t.Errorf("Atteso errore per la colonna Email mancante, ma non si è verificato")
+	}
+
+	// Campo sconosciuto nella mappatura
+	if _, err := ParseColumnMapping("user_name=Nome"); err == nil {
+		t.Errorf("Atteso errore per un campo sconosciuto, ma non si è verificato")
+	}
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"csvreader/pkg/constants"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression identifica il formato di compressione di un file di input
+type Compression string
+
+const (
+	CompressionNone  Compression = "none"
+	CompressionGzip  Compression = "gzip"
+	CompressionZstd  Compression = "zstd"
+	CompressionBzip2 Compression = "bzip2"
+)
+
+// Magic bytes iniziali dei formati supportati; bzip2 è seguito dalla dimensione dei blocchi, da '1' a '9'
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+)
+
+// detectCompression riconosce la compressione dall'estensione del nome del file e,
+// solo se l'estensione non è quella di un formato noto, dai primi byte del file
+func detectCompression(filename string, head []byte) Compression {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gz", ".gzip":
+		return CompressionGzip
+	case ".zst", ".zstd":
+		return CompressionZstd
+	case ".bz2", ".bzip2":
+		return CompressionBzip2
+	}
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(head, zstdMagic):
+		return CompressionZstd
+	case len(head) >= len(bzip2Magic)+1 && bytes.HasPrefix(head, bzip2Magic) &&
+		head[len(bzip2Magic)] >= '1' && head[len(bzip2Magic)] <= '9':
+		return CompressionBzip2
+	}
+	return CompressionNone
+}
+
+// detectFileCompression legge i primi byte del file per riconoscerne la compressione
+func detectFileCompression(filename string) (Compression, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return CompressionNone, fmt.Errorf(constants.FileOpenErrMessage, err)
+	}
+	defer safelyClose(file)
+
+	head := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return CompressionNone, fmt.Errorf(constants.FileOpenErrMessage, err)
+	}
+	return detectCompression(filename, head[:n]), nil
+}
+
+// compressedFile unisce il decompressore e il file sottostante, così una sola Close rilascia entrambi
+type compressedFile struct {
+	io.Reader
+	closeDecoder func() error
+	file         *os.File
+}
+
+func (c *compressedFile) Close() error {
+	err := c.closeDecoder()
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// OpenInput opens 'filename' for reading and transparently decompresses gzip, zstd and bzip2 content,
+// detected by extension or, for any other extension, by magic bytes. Plain files are returned as they are.
+// The data is decompressed while it is read, so nothing is staged on disk.
+// The caller must close the returned reader, which also closes the file.
+func OpenInput(filename string) (io.ReadCloser, Compression, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, CompressionNone, fmt.Errorf(constants.FileOpenErrMessage, err)
+	}
+
+	buffered := bufio.NewReaderSize(file, constants.ReadBufferSize)
+	head, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		safelyClose(file)
+		return nil, CompressionNone, fmt.Errorf(constants.FileOpenErrMessage, err)
+	}
+
+	compression := detectCompression(filename, head)
+	input := &compressedFile{file: file, closeDecoder: func() error { return nil }}
+
+	switch compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			safelyClose(file)
+			return nil, compression, fmt.Errorf("errore durante l'apertura del file gzip %s: %v", filename, err)
+		}
+		input.Reader, input.closeDecoder = gz, gz.Close
+	case CompressionZstd:
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			safelyClose(file)
+			return nil, compression, fmt.Errorf("errore durante l'apertura del file zstd %s: %v", filename, err)
+		}
+		input.Reader = zr
+		input.closeDecoder = func() error {
+			zr.Close()
+			return nil
+		}
+	case CompressionBzip2:
+		input.Reader = bzip2.NewReader(buffered)
+	default:
+		input.Reader = buffered
+	}
+	return input, compression, nil
+}
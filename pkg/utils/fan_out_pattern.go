@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"csvreader/internal/models"
+	"csvreader/pkg/constants"
 	"sync"
 )
 
@@ -60,3 +62,34 @@ func Worker(ch <-chan func(), wg *sync.WaitGroup) {
 		task()
 	}
 }
+
+// Tee duplica ogni utente ricevuto da ch su n canali, così più consumer possono leggere lo stesso flusso.
+// A differenza di Split non si tratta di round-robin: ogni canale riceve tutti gli utenti, quindi il flusso
+// avanza alla velocità del consumer più lento e ogni canale deve essere letto fino in fondo (o svuotato con DrainUsers).
+// Quando ch viene chiuso, vengono chiusi anche tutti i canali restituiti.
+func Tee(ch <-chan models.User, n int) []<-chan models.User {
+	cs := make([]chan models.User, n)
+	for i := 0; i < n; i++ {
+		cs[i] = make(chan models.User, constants.StreamBufferSize)
+	}
+
+	go func() {
+		defer func() {
+			for _, c := range cs {
+				close(c)
+			}
+		}()
+
+		for user := range ch {
+			for _, c := range cs {
+				c <- user
+			}
+		}
+	}()
+
+	result := make([]<-chan models.User, n)
+	for i := 0; i < n; i++ {
+		result[i] = cs[i]
+	}
+	return result
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"bufio"
+	"csvreader/internal/models"
+	"csvreader/pkg/constants"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FixedWidthColumn indica dove si trova un campo di models.User in una riga a larghezza fissa
+type FixedWidthColumn struct {
+	Field string // campo di models.User (FieldID, FieldNomeUtente, FieldEmail)
+	Start int    // posizione del primo byte, a partire da 0
+	Width int    // numero di byte
+}
+
+// ParseFixedWidthColumns parses a layout written as "Field:start:width" triples separated by commas,
+// for example "ID:0:10,NomeUtente:10:30,Email:40:50". Values are trimmed of padding spaces when read.
+func ParseFixedWidthColumns(s string) ([]FixedWidthColumn, error) {
+	var columns []FixedWidthColumn
+	for _, spec := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(spec), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("colonna a larghezza fissa non valida %q: atteso formato Campo:inizio:larghezza", spec)
+		}
+		start, errStart := strconv.Atoi(parts[1])
+		width, errWidth := strconv.Atoi(parts[2])
+		if errStart != nil || errWidth != nil {
+			return nil, fmt.Errorf("colonna a larghezza fissa non valida %q: inizio e larghezza devono essere numeri", spec)
+		}
+		columns = append(columns, FixedWidthColumn{Field: parts[0], Start: start, Width: width})
+	}
+
+	if err := validateFixedWidth(columns); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+// validateFixedWidth controlla che ogni campo obbligatorio sia presente una sola volta e con una posizione valida
+func validateFixedWidth(columns []FixedWidthColumn) error {
+	seen := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if !isUserField(column.Field) {
+			return fmt.Errorf("colonna a larghezza fissa: campo %q sconosciuto, campi ammessi: %s",
+				column.Field, strings.Join(requiredFields, ", "))
+		}
+		if column.Start < 0 || column.Width <= 0 {
+			return fmt.Errorf("colonna a larghezza fissa %s: inizio %d e larghezza %d non validi", column.Field, column.Start, column.Width)
+		}
+		if seen[column.Field] {
+			return fmt.Errorf("colonna a larghezza fissa: campo %s definito più volte", column.Field)
+		}
+		seen[column.Field] = true
+	}
+
+	for _, field := range requiredFields {
+		if !seen[field] {
+			return fmt.Errorf("colonna a larghezza fissa mancante per il campo %s", field)
+		}
+	}
+	return nil
+}
+
+// fixedWidthReader legge righe di testo a larghezza fissa, senza intestazione
+type fixedWidthReader struct {
+	cfg CSVConfig
+}
+
+// ReadUsers reads one user per line, slicing every field at its configured position.
+// The last field of a line may be shorter than its width (trailing spaces are often trimmed), empty lines are skipped.
+func (f fixedWidthReader) ReadUsers(r io.Reader, filename string, emit func(models.User)) error {
+	scanner := bufio.NewScanner(bufio.NewReaderSize(r, constants.ReadBufferSize))
+	scanner.Buffer(make([]byte, 64*1024), constants.MaxLineSize)
+	src := rowSource{filename: filename}
+
+	var offset int64
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		start := offset
+		offset += int64(len(scanner.Bytes())) + 1
+
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		user, err := f.createUser(text)
+		if err == nil {
+			err = f.cfg.validate(user)
+		}
+		if err != nil {
+			if err := rejectRow(f.cfg, src, line, start, text, err); err != nil {
+				return err
+			}
+			continue
+		}
+		user.SourceLine = line
+		emit(user)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf(constants.RecordsReadErrMessage, err)
+	}
+	return nil
+}
+
+func (f fixedWidthReader) createUser(line string) (models.User, error) {
+	var user models.User
+	for _, column := range f.cfg.FixedWidth {
+		if column.Start >= len(line) {
+			return models.User{}, fmt.Errorf("riga di %d byte, il campo %s inizia al byte %d", len(line), column.Field, column.Start)
+		}
+		value := strings.TrimSpace(line[column.Start:min(column.Start+column.Width, len(line))])
+
+		switch column.Field {
+		case FieldID:
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return models.User{}, fmt.Errorf("errore durante la conversione dell'id: %v", err)
+			}
+			user.ID = id
+		case FieldNomeUtente:
+			user.NomeUtente = value
+		case FieldEmail:
+			user.Email = value
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"csvreader/internal/models"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// InputFormat identifica il formato dei file di input
+type InputFormat string
+
+const (
+	FormatCSV        InputFormat = "csv"   // CSV con intestazione e separatore configurabile
+	FormatTSV        InputFormat = "tsv"   // CSV con intestazione separato da tab
+	FormatJSONLines  InputFormat = "jsonl" // un oggetto JSON per riga
+	FormatFixedWidth InputFormat = "fixed" // testo a larghezza fissa, senza intestazione
+)
+
+// ParseInputFormat converts the configured format name into an InputFormat, an empty name meaning CSV.
+func ParseInputFormat(name string) (InputFormat, error) {
+	switch format := InputFormat(strings.ToLower(strings.TrimSpace(name))); format {
+	case "":
+		return FormatCSV, nil
+	case FormatCSV, FormatTSV, FormatJSONLines, FormatFixedWidth:
+		return format, nil
+	default:
+		return "", fmt.Errorf("formato di input %q non supportato, formati ammessi: %s, %s, %s, %s",
+			name, FormatCSV, FormatTSV, FormatJSONLines, FormatFixedWidth)
+	}
+}
+
+// splittable indica se il formato può essere diviso in chunk dal parsing parallelo
+func (f InputFormat) splittable() bool {
+	return f == "" || f == FormatCSV || f == FormatTSV
+}
+
+// UserReader legge gli utenti da una sorgente in un formato specifico.
+// Le implementazioni gestiscono le righe malformate come il CSV: quarantena in modalità tollerante,
+// altrimenti la prima riga malformata interrompe la lettura.
+type UserReader interface {
+	// ReadUsers reads every user from 'r' and calls 'emit' for each valid one, in input order.
+	// 'filename' is only used to describe the rejected rows.
+	ReadUsers(r io.Reader, filename string, emit func(models.User)) error
+}
+
+// NewUserReader returns the UserReader for cfg.Format. The rest of the pipeline only sees models.User,
+// so the JSON/Avro writers and the Kafka producers do not depend on the input format.
+// It returns an error for an unknown format or an invalid fixed-width layout.
+func NewUserReader(cfg CSVConfig) (UserReader, error) {
+	switch cfg.Format {
+	case "", FormatCSV:
+		return csvUserReader{cfg: cfg}, nil
+	case FormatTSV:
+		cfg.Separator = '\t'
+		return csvUserReader{cfg: cfg}, nil
+	case FormatJSONLines:
+		return jsonLinesReader{cfg: cfg}, nil
+	case FormatFixedWidth:
+		if err := validateFixedWidth(cfg.FixedWidth); err != nil {
+			return nil, err
+		}
+		return fixedWidthReader{cfg: cfg}, nil
+	default:
+		return nil, fmt.Errorf("formato di input %q non supportato", cfg.Format)
+	}
+}
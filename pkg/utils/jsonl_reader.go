@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"csvreader/internal/models"
+	"csvreader/pkg/constants"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// jsonLinesReader legge un oggetto JSON per riga; le chiavi vengono associate ai campi con la stessa ColumnMapping del CSV
+type jsonLinesReader struct {
+	cfg CSVConfig
+}
+
+// ReadUsers reads one JSON object per line. The ID can be a JSON number or a numeric string,
+// unknown keys are ignored and empty lines are skipped.
+func (j jsonLinesReader) ReadUsers(r io.Reader, filename string, emit func(models.User)) error {
+	scanner := bufio.NewScanner(bufio.NewReaderSize(r, constants.ReadBufferSize))
+	scanner.Buffer(make([]byte, 64*1024), constants.MaxLineSize)
+	src := rowSource{filename: filename}
+
+	var offset int64
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+		start := offset
+		offset += int64(len(data)) + 1
+
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		user, err := j.createUser(data)
+		if err == nil {
+			err = j.cfg.validate(user)
+		}
+		if err != nil {
+			if err := rejectRow(j.cfg, src, line, start, string(data), err); err != nil {
+				return err
+			}
+			continue
+		}
+		user.SourceLine = line
+		emit(user)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf(constants.RecordsReadErrMessage, err)
+	}
+	return nil
+}
+
+func (j jsonLinesReader) createUser(data []byte) (models.User, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return models.User{}, fmt.Errorf("oggetto JSON non valido: %v", err)
+	}
+
+	values := make(map[string]json.RawMessage, len(requiredFields))
+	for key, value := range object {
+		if field, ok := j.cfg.Mapping[normalizeColumn(key)]; ok {
+			values[field] = value
+		}
+	}
+	for _, field := range requiredFields {
+		if _, ok := values[field]; !ok {
+			return models.User{}, fmt.Errorf("campo obbligatorio %s mancante", field)
+		}
+	}
+
+	var user models.User
+	idValue := bytes.Trim(values[FieldID], `"`) // l'id può essere un numero o una stringa numerica
+	id, err := strconv.Atoi(string(idValue))
+	if err != nil {
+		return models.User{}, fmt.Errorf("errore durante la conversione dell'id: %v", err)
+	}
+	user.ID = id
+	if err := json.Unmarshal(values[FieldNomeUtente], &user.NomeUtente); err != nil {
+		return models.User{}, fmt.Errorf("campo %s non valido: %v", FieldNomeUtente, err)
+	}
+	if err := json.Unmarshal(values[FieldEmail], &user.Email); err != nil {
+		return models.User{}, fmt.Errorf("campo %s non valido: %v", FieldEmail, err)
+	}
+	return user, nil
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"csvreader/internal/models"
+	"csvreader/pkg/constants"
+	"csvreader/pkg/logger"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inputExtensions sono, per ogni formato, le estensioni considerate quando l'input è una directory,
+// anche seguite da quella di un formato compresso (es. users.csv.gz)
+var (
+	inputExtensions = map[InputFormat][]string{
+		FormatCSV:        {".csv"},
+		FormatTSV:        {".tsv", ".tab"},
+		FormatJSONLines:  {".jsonl", ".ndjson"},
+		FormatFixedWidth: {".txt", ".dat"},
+	}
+	compressionExtensions = []string{"", ".gz", ".zst", ".zstd", ".bz2"}
+)
+
+// ResolveInputs expands the configured input into the list of files to ingest, sorted by name.
+// 'input' can be a single file, a glob such as "exports/users_*.csv" or a directory: in the last case
+// every file it contains with an extension of 'format' (also compressed, see inputExtensions)
+// is ingested, subdirectories excluded.
+// It returns an error if nothing matches.
+func ResolveInputs(input string, format InputFormat) ([]string, error) {
+	info, err := os.Stat(input)
+	if err == nil && info.IsDir() {
+		entries, err := os.ReadDir(input)
+		if err != nil {
+			return nil, fmt.Errorf("errore durante la lettura della directory %s: %v", input, err)
+		}
+
+		var files []string
+		for _, entry := range entries {
+			if !entry.IsDir() && hasInputExtension(entry.Name(), format) {
+				files = append(files, filepath.Join(input, entry.Name()))
+			}
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("nessun file %s trovato nella directory %s", format, input)
+		}
+		return files, nil // os.ReadDir restituisce le voci già ordinate per nome
+	}
+
+	files, err := filepath.Glob(input)
+	if err != nil {
+		return nil, fmt.Errorf("pattern di input non valido %q: %v", input, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("nessun file corrisponde all'input %q", input)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func hasInputExtension(name string, format InputFormat) bool {
+	if format == "" {
+		format = FormatCSV
+	}
+
+	name = strings.ToLower(name)
+	for _, ext := range inputExtensions[format] {
+		for _, compressed := range compressionExtensions {
+			if strings.HasSuffix(name, ext+compressed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FileStats contiene l'avanzamento e gli errori di un singolo file di input
+type FileStats struct {
+	File     string
+	Users    int           // utenti letti e inviati nello stream
+	Rejected int           // righe scartate nella quarantena
+	Duration time.Duration // durata della lettura
+	Err      error         // errore che ha interrotto la lettura del file, se presente
+}
+
+// IngestionReport raccoglie le statistiche per file di una esecuzione.
+// Viene aggiornato mentre lo stream avanza ed è completo quando il canale degli utenti viene chiuso.
+type IngestionReport struct {
+	mu    sync.Mutex
+	files []FileStats
+}
+
+// Files returns a copy of the statistics collected so far, one entry per file in ingestion order.
+func (r *IngestionReport) Files() []FileStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]FileStats(nil), r.files...)
+}
+
+// Log writes one line per file with its users, rejected rows, duration and error, if any.
+func (r *IngestionReport) Log() {
+	for _, stats := range r.Files() {
+		if stats.Err != nil {
+			logger.ErrorAsync("File ", stats.File, ": ", stats.Users, " users, ", stats.Rejected,
+				" rejected rows, failed after ", stats.Duration, ": ", stats.Err)
+			continue
+		}
+		logger.InfoAsync("File ", stats.File, ": ", stats.Users, " users, ", stats.Rejected,
+			" rejected rows in ", stats.Duration)
+	}
+}
+
+func (r *IngestionReport) add(stats FileStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = append(r.files, stats)
+}
+
+// StreamFiles reads the given files one after the other with StreamCSVWithConfig and merges them
+// into a single stream, so that all of them are ingested in the same run. Every user carries the file
+// it comes from in models.User.SourceFile and its 1-based position in the whole stream in models.User.RowOffset.
+// An error in one file is recorded in the report and the next files are still read; the error channel
+// then receives all the file errors joined together. Exceeding the quarantine threshold stops the whole run instead.
+func StreamFiles(filenames []string, cfg CSVConfig) (<-chan models.User, <-chan error, *IngestionReport) {
+	usersCh := make(chan models.User, constants.StreamBufferSize)
+	errCh := make(chan error, 1)
+	report := &IngestionReport{}
+
+	go func() {
+		defer close(errCh)
+		defer close(usersCh)
+
+		var errs []error
+		var rowOffset int64
+		for i, filename := range filenames {
+			logger.InfoAsync("Reading file ", i+1, "/", len(filenames), ": ", filename)
+			stats := streamFile(filename, cfg, usersCh, &rowOffset)
+			report.add(stats)
+
+			if stats.Err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", filename, stats.Err))
+				if errors.Is(stats.Err, ErrTooManyRejected) {
+					break
+				}
+			}
+		}
+
+		if err := errors.Join(errs...); err != nil {
+			errCh <- err
+		}
+	}()
+
+	return usersCh, errCh, report
+}
+
+// streamFile inoltra gli utenti di un file nello stream comune, aggiungendo il file di origine e contando l'avanzamento
+func streamFile(filename string, cfg CSVConfig, usersCh chan<- models.User, rowOffset *int64) FileStats {
+	start := time.Now()
+	stats := FileStats{File: filename}
+
+	fileUsers, fileErrs := StreamCSVWithConfig(filename, cfg)
+	for user := range fileUsers {
+		*rowOffset++
+		user.SourceFile, user.RowOffset = filename, *rowOffset
+		usersCh <- user
+
+		stats.Users++
+		if stats.Users%constants.ProgressEvery == 0 {
+			logger.InfoAsync("File ", filename, ": ", stats.Users, " users read")
+		}
+	}
+
+	stats.Err = <-fileErrs
+	stats.Duration = time.Since(start)
+	if cfg.Quarantine != nil {
+		stats.Rejected = cfg.Quarantine.RejectedIn(filename)
+	}
+	return stats
+}
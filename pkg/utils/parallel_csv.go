@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"bytes"
+	"csvreader/internal/models"
+	"csvreader/pkg/constants"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// csvChunk è un intervallo di byte [start, end) del file che inizia e finisce al confine di un record
+type csvChunk struct {
+	index int
+	start int64
+	end   int64
+}
+
+// chunkResult contiene gli utenti estratti da un chunk, oppure l'errore che ne ha interrotto il parsing
+type chunkResult struct {
+	index int
+	users []models.User
+	err   error
+}
+
+// StreamCSVParallel reads the CSV file splitting it into byte-range chunks of about cfg.ChunkSize bytes,
+// aligned to record boundaries, and parses them on cfg.Workers goroutines. With cfg.Ordered the users are
+// sent in the same order as in the file, otherwise every chunk is sent as soon as it is parsed, for maximum throughput.
+// The channels behave like the ones returned by StreamCSV.
+//
+// Chunks are aligned on newlines, so the file must not contain quoted fields spanning multiple lines.
+// Compressed files cannot be split: they are read sequentially by StreamCSVWithConfig.
+// In tolerant mode the rejected rows carry their byte offset, since the absolute line number is not known.
+func StreamCSVParallel(filename string, cfg CSVConfig) (<-chan models.User, <-chan error) {
+	if cfg.Format == FormatTSV {
+		cfg.Separator = '\t'
+	}
+
+	usersCh := make(chan models.User, constants.StreamBufferSize)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(errCh)
+		defer close(usersCh)
+
+		file, err := os.Open(filename) // apro il file
+		if err != nil {
+			errCh <- fmt.Errorf(constants.FileOpenErrMessage, err)
+			return
+		}
+		defer safelyClose(file) // chiudo per non sprecare risorse
+
+		if err := parseChunks(file, filename, cfg, usersCh); err != nil {
+			errCh <- err
+		}
+	}()
+
+	return usersCh, errCh
+}
+
+// parseChunks legge l'intestazione, divide il resto del file in chunk e coordina i worker che li analizzano
+func parseChunks(file *os.File, filename string, cfg CSVConfig, usersCh chan<- models.User) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf(constants.FileOpenErrMessage, err)
+	}
+	size := info.Size()
+
+	// L'intestazione viene letta a parte: i chunk contengono solo record di dati
+	headerReader, _ := newCSVReader(io.NewSectionReader(file, 0, size), cfg)
+	header, err := headerReader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return fmt.Errorf(constants.RecordsReadErrMessage, err)
+	}
+	index, err := newColumnIndex(header, cfg.Mapping)
+	if err != nil {
+		return fmt.Errorf(constants.HeaderErrMessage, err)
+	}
+
+	chunks, err := splitChunks(file, headerReader.InputOffset(), size, cfg.ChunkSize)
+	if err != nil {
+		return err
+	}
+
+	workers := max(cfg.Workers, 1)
+	jobs := make(chan csvChunk)
+	results := make(chan chunkResult, workers)
+	// inFlight limita i chunk analizzati ma non ancora inviati, così la memoria resta limitata anche in modalità ordinata
+	inFlight := make(chan struct{}, 2*workers)
+	stop := make(chan struct{})
+
+	// Distribuisce i chunk ai worker, in ordine, finché non viene richiesto lo stop
+	go func() {
+		defer close(jobs)
+		for _, chunk := range chunks {
+			select {
+			case inFlight <- struct{}{}:
+			case <-stop:
+				return
+			}
+			select {
+			case jobs <- chunk:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for chunk := range jobs {
+				results <- parseChunk(file, chunk, index, filename, cfg)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Raccoglie i risultati: in modalità ordinata i chunk arrivati in anticipo attendono il loro turno
+	var firstErr error
+	pending := make(map[int][]models.User)
+	next := 0
+	for result := range results {
+		if firstErr != nil {
+			continue // si svuota results finché tutti i worker non hanno terminato
+		}
+		if result.err != nil {
+			firstErr = result.err
+			close(stop)
+			continue
+		}
+
+		if !cfg.Ordered {
+			sendUsers(result.users, usersCh)
+			<-inFlight
+			continue
+		}
+		pending[result.index] = result.users
+		for users, ok := pending[next]; ok; users, ok = pending[next] {
+			sendUsers(users, usersCh)
+			delete(pending, next)
+			<-inFlight
+			next++
+		}
+	}
+
+	return firstErr
+}
+
+// splitChunks divide l'intervallo [dataStart, size) in chunk di circa chunkSize byte che terminano a fine riga
+func splitChunks(file io.ReaderAt, dataStart, size, chunkSize int64) ([]csvChunk, error) {
+	if chunkSize <= 0 {
+		chunkSize = constants.ChunkSize
+	}
+
+	var chunks []csvChunk
+	for start := dataStart; start < size; {
+		end, err := alignToRecord(file, start+chunkSize, size)
+		if err != nil {
+			return nil, fmt.Errorf(constants.RecordsReadErrMessage, err)
+		}
+		chunks = append(chunks, csvChunk{index: len(chunks), start: start, end: end})
+		start = end
+	}
+	return chunks, nil
+}
+
+// alignToRecord restituisce il primo inizio di riga a partire da pos (pos stesso se è già un inizio di riga),
+// oppure size se il file finisce prima
+func alignToRecord(file io.ReaderAt, pos, size int64) (int64, error) {
+	if pos >= size {
+		return size, nil
+	}
+
+	buf := make([]byte, 64*1024)
+	for offset := pos - 1; offset < size; offset += int64(len(buf)) {
+		n, err := file.ReadAt(buf, offset)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return offset + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// parseChunk analizza i record di un singolo chunk
+func parseChunk(file io.ReaderAt, chunk csvChunk, index columnIndex, filename string, cfg CSVConfig) chunkResult {
+	reader, raw := newCSVReader(io.NewSectionReader(file, chunk.start, chunk.end-chunk.start), cfg)
+	src := rowSource{filename: filename, baseOffset: chunk.start, chunked: true}
+
+	result := chunkResult{index: chunk.index}
+	result.err = readUsers(reader, raw, index, cfg, src, func(user models.User) {
+		result.users = append(result.users, user)
+	})
+	return result
+}
+
+func sendUsers(users []models.User, usersCh chan<- models.User) {
+	for _, user := range users {
+		usersCh <- user
+	}
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"bufio"
+	"csvreader/internal/models"
+	"csvreader/internal/models/userpb"
+	"csvreader/pkg/constants"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+)
+
+// WriteProtobufStreamToFile scrive gli utenti ricevuti dal canale come messaggi userpb.User, ognuno preceduto
+// dalla sua lunghezza in varint (come writeDelimitedTo di Java), senza tenere tutto in memoria.
+// In caso di errore il canale viene comunque svuotato.
+func WriteProtobufStreamToFile(users <-chan models.User, filename string) error {
+	defer DrainUsers(users)
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("errore durante la scrittura dei dati Protobuf su file: %v", err)
+	}
+	defer safelyClose(file)
+
+	writer := bufio.NewWriterSize(file, constants.WriteBufferSize)
+	for user := range users {
+		if _, err := protodelim.MarshalTo(writer, userpb.FromUser(user)); err != nil {
+			return fmt.Errorf("errore durante la scrittura dei dati Protobuf su file: %v", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("errore durante la scrittura dei dati Protobuf su file: %v", err)
+	}
+	return nil
+}
+
+// WriteProtobufToFile writes the users to 'filename' as length-delimited userpb.User messages,
+// readable with ReadProtobufFile or with parseDelimitedFrom in Java.
+func WriteProtobufToFile(users []models.User, filename string) error {
+	stream := make(chan models.User)
+	go func() {
+		defer close(stream)
+		for _, user := range users {
+			stream <- user
+		}
+	}()
+	return WriteProtobufStreamToFile(stream, filename)
+}
+
+// ReadProtobufFile reads back the users of a file written by WriteProtobufToFile.
+func ReadProtobufFile(filename string) ([]models.User, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("errore durante la lettura del file Protobuf %s: %v", filename, err)
+	}
+	defer safelyClose(file)
+
+	reader := bufio.NewReaderSize(file, constants.ReadBufferSize)
+	var users []models.User
+	for {
+		var message userpb.User
+		err := protodelim.UnmarshalFrom(reader, &message)
+		if errors.Is(err, io.EOF) {
+			return users, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("errore durante la lettura del file Protobuf %s: %v", filename, err)
+		}
+		users = append(users, message.ToUser())
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrTooManyRejected is returned by Quarantine.Reject once the max-error threshold has been exceeded.
+var ErrTooManyRejected = errors.New("troppe righe scartate")
+
+// RejectedRow è una riga del CSV scartata, così come viene scritta nel file di quarantena (una riga JSON per record)
+type RejectedRow struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"` // non disponibile per i chunk del parsing parallelo
+	Raw    string `json:"raw"`
+	Reason string `json:"reason"`
+	Offset int64  `json:"offset"` // offset in byte del record nel file
+}
+
+// Quarantine raccoglie le righe scartate in un file JSON Lines, invece di interrompere l'intera esecuzione al primo errore.
+// È sicura per l'uso concorrente, così più lettori possono condividere lo stesso file e la stessa soglia.
+type Quarantine struct {
+	mu        sync.Mutex
+	file      *os.File
+	writer    *bufio.Writer
+	filename  string
+	maxErrors int
+	rejected  int
+	byFile    map[string]int
+}
+
+// NewQuarantine creates (or truncates) the quarantine file 'filename'.
+// 'maxErrors' is the maximum number of rejected rows tolerated in the run: once it is exceeded,
+// Reject returns ErrTooManyRejected and the run should be aborted. A value <= 0 means no limit.
+func NewQuarantine(filename string, maxErrors int) (*Quarantine, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("errore durante la creazione del file di quarantena: %v", err)
+	}
+
+	return &Quarantine{
+		file:      file,
+		writer:    bufio.NewWriter(file),
+		filename:  filename,
+		maxErrors: maxErrors,
+		byFile:    make(map[string]int),
+	}, nil
+}
+
+// Reject writes the row to the quarantine file with its line number, raw content and reason.
+// It returns ErrTooManyRejected (wrapped with the counts) when the row exceeds the configured threshold,
+// or the write error if the quarantine file cannot be written.
+func (q *Quarantine) Reject(row RejectedRow) error {
+	line, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("errore durante la conversione in JSON della riga scartata: %v", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := q.writer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("errore durante la scrittura nel file di quarantena: %v", err)
+	}
+	q.rejected++
+	q.byFile[row.File]++
+
+	if q.maxErrors > 0 && q.rejected > q.maxErrors {
+		return fmt.Errorf("%w: %d (massimo %d), vedi %s", ErrTooManyRejected, q.rejected, q.maxErrors, q.filename)
+	}
+	return nil
+}
+
+// Rejected returns how many rows have been rejected so far.
+func (q *Quarantine) Rejected() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.rejected
+}
+
+// RejectedIn returns how many rows of the input file 'file' have been rejected so far.
+func (q *Quarantine) RejectedIn(file string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.byFile[file]
+}
+
+// Filename returns the path of the quarantine file.
+func (q *Quarantine) Filename() string {
+	return q.filename
+}
+
+// Close flushes the pending rows and closes the quarantine file.
+func (q *Quarantine) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.writer.Flush(); err != nil {
+		safelyClose(q.file)
+		return fmt.Errorf("errore durante la scrittura nel file di quarantena: %v", err)
+	}
+	return q.file.Close()
+}
@@ -1,85 +1,345 @@
 package utils
 
 import (
+	"bufio"
 	"bytes"
 	"csvreader/internal/models"
 	"csvreader/pkg/constants"
 	"csvreader/pkg/logger"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"strings"
 
-	"github.com/linkedin/goavro/v2"
 	"github.com/pquerna/ffjson/ffjson"
 )
 
 // ReadCSV reads a CSV file containing user data and returns a slice of models.User objects.
-// It opens the file, reads the records using csv package, creates User objects from the records,
-// and appends them to the users slice. It skips the first record, assuming it is a header.
+// It is a thin wrapper around StreamCSV that collects every streamed user into a slice,
+// so the whole file ends up in memory: prefer StreamCSV for large inputs.
+// It uses the constants.UsersFile constant to specify the file path and DefaultCSVConfig to read it.
 // If an error occurs during file opening, records reading, or user creation, it returns the error.
-// It uses the constants.UsersFile constant to specify the file path, and constants.Separator constant
-// to set the CSV field separator. It also calls the safelyClose function to close the file safely.
 func ReadCSV() ([]models.User, error) {
-	file, err := os.Open(constants.UsersFile) // apro il file
+	usersCh, errCh := StreamCSV(constants.UsersFile)
+
+	var users []models.User
+	for user := range usersCh {
+		users = append(users, user)
+	}
+	if err := <-errCh; err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// CSVConfig contains the settings used to read the users input files.
+// Despite the name, the input is not limited to CSV: Format selects the UserReader (see NewUserReader).
+type CSVConfig struct {
+	Format     InputFormat        // formato dei file di input, CSV se vuoto
+	Separator  rune               // separatore dei campi (solo CSV, il TSV usa sempre il tab)
+	Mapping    ColumnMapping      // associazione tra le colonne dell'intestazione (o le chiavi JSON) e i campi di models.User
+	FixedWidth []FixedWidthColumn // posizione dei campi nel formato a larghezza fissa
+
+	// Quarantine, se impostata, attiva la modalità tollerante: le righe malformate vengono scritte
+	// nella quarantena e saltate, finché non si supera la soglia configurata.
+	// Se è nil, la prima riga malformata interrompe la lettura.
+	Quarantine *Quarantine
+
+	// Validator, se impostato, applica le regole di validazione a ogni utente letto:
+	// gli utenti non validi vengono trattati come righe malformate (quarantena o errore)
+	Validator *Validator
+
+	// Parsing parallelo: con Workers > 1 il file viene diviso in chunk di circa ChunkSize byte
+	// analizzati in parallelo (vedi StreamCSVParallel). Ordered mantiene l'ordine originale dei record.
+	Workers   int
+	ChunkSize int64
+	Ordered   bool
+}
+
+// DefaultCSVConfig returns the configuration matching constants.UsersFile:
+// constants.Separator as separator and DefaultColumnMapping for the header.
+func DefaultCSVConfig() CSVConfig {
+	return CSVConfig{
+		Format:    FormatCSV,
+		Separator: constants.Separator,
+		Mapping:   DefaultColumnMapping(),
+		Workers:   1,
+		ChunkSize: constants.ChunkSize,
+		Ordered:   true,
+	}
+}
+
+// StreamCSV reads the CSV file at 'filename' row by row using DefaultCSVConfig and sends every models.User
+// on the returned channel, so that consumers can start working while the file is still being read and memory stays flat.
+// The first record is the header: its column names decide which column feeds each models.User field.
+// The users channel is closed when the file is over or when an error occurs; the error channel receives
+// at most one error and is closed right after, so callers should range over the users and then read the error:
+//
+//	users, errs := StreamCSV(filename)
+//	for user := range users { ... }
+//	if err := <-errs; err != nil { ... }
+//
+// Consumers that stop early must drain the users channel (see DrainUsers), otherwise the reader stays blocked.
+func StreamCSV(filename string) (<-chan models.User, <-chan error) {
+	return StreamCSVWithConfig(filename, DefaultCSVConfig())
+}
+
+// StreamCSVWithConfig works like StreamCSV but reads the file with the given configuration,
+// in the format selected by cfg.Format (see NewUserReader).
+// If a required column is missing from the header, no user is sent and the error is returned right away.
+// Compressed files (gzip, zstd, bzip2) are decompressed on the fly, see OpenInput.
+// When cfg.Quarantine is set, malformed rows are written to the quarantine and skipped instead of stopping the stream.
+// With cfg.Workers > 1 plain CSV/TSV files are parsed in parallel by StreamCSVParallel.
+func StreamCSVWithConfig(filename string, cfg CSVConfig) (<-chan models.User, <-chan error) {
+	usersCh := make(chan models.User, constants.StreamBufferSize)
+	errCh := make(chan error, 1)
+
+	userReader, err := NewUserReader(cfg)
 	if err != nil {
-		return nil, fmt.Errorf(constants.FileOpenErrMessage, err)
+		close(usersCh)
+		errCh <- err
+		close(errCh)
+		return usersCh, errCh
 	}
-	defer safelyClose(file) // chiudo per non sprecare risorse
 
-	var users []models.User
-	reader := csv.NewReader(file)
-	reader.Comma = constants.Separator
-	records, err := reader.ReadAll()
+	if cfg.Workers > 1 {
+		compression, err := detectFileCompression(filename)
+		if err == nil && compression == CompressionNone && cfg.Format.splittable() {
+			return StreamCSVParallel(filename, cfg)
+		}
+		if err == nil {
+			logger.WarningAsync("Parallel parsing is not available for ", cfg.Format, "/", compression, " input ", filename, ", reading it sequentially")
+		}
+	}
+
+	go func() {
+		defer close(errCh)
+		defer close(usersCh)
+
+		// apro il file, decomprimendolo al volo se è gzip, zstd o bzip2
+		input, compression, err := OpenInput(filename)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer safelyCloseReader(input) // chiudo per non sprecare risorse
+		if compression != CompressionNone {
+			logger.InfoAsync("Reading ", compression, " compressed input ", filename)
+		}
+
+		if err := userReader.ReadUsers(input, filename, func(user models.User) {
+			usersCh <- user
+		}); err != nil {
+			errCh <- err
+		}
+	}()
+
+	return usersCh, errCh
+}
 
+// csvUserReader legge CSV e TSV con intestazione
+type csvUserReader struct {
+	cfg CSVConfig
+}
+
+// ReadUsers parses the CSV read from 'r' and calls 'emit' for every valid record.
+// 'filename' is only used to describe the rejected rows. It returns the first error that stops the reading:
+// with a quarantine configured, this only happens for I/O errors, a bad header or too many rejected rows.
+func (c csvUserReader) ReadUsers(r io.Reader, filename string, emit func(models.User)) error {
+	reader, raw := newCSVReader(r, c.cfg)
+
+	// L'intestazione decide in quale colonna si trova ciascun campo
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return fmt.Errorf(constants.RecordsReadErrMessage, err)
+	}
+	index, err := newColumnIndex(header, c.cfg.Mapping)
 	if err != nil {
-		return nil, fmt.Errorf(constants.RecordsReadErrMessage, err)
+		return fmt.Errorf(constants.HeaderErrMessage, err)
+	}
+
+	return readUsers(reader, raw, index, c.cfg, rowSource{filename: filename}, emit)
+}
+
+// newCSVReader crea il csv.Reader configurato secondo cfg. Con la quarantena configurata restituisce anche
+// il rawRecorder dei byte letti, per scartare le righe con il loro contenuto originale (altrimenti nil)
+func newCSVReader(r io.Reader, cfg CSVConfig) (*csv.Reader, *rawRecorder) {
+	var raw *rawRecorder
+	if cfg.Quarantine != nil {
+		raw = &rawRecorder{r: r}
+		r = raw
+	}
+	reader := csv.NewReader(bufio.NewReaderSize(r, constants.ReadBufferSize))
+	reader.Comma = cfg.Separator
+	reader.ReuseRecord = true // il record viene convertito subito, si evita un'allocazione per riga
+	if cfg.Quarantine != nil {
+		reader.FieldsPerRecord = -1 // i record corti vengono scartati da createUser, non da csv.Reader
 	}
-	// Itero i record, saltando il primo (records[1:])
-	for _, record := range records[1:] {
-		user, err := createUserFromRecord(record)
+	return reader, raw
+}
+
+// rawRecorder conserva i byte letti dal csv.Reader che non sono ancora stati consumati da un record,
+// così una riga scartata finisce in quarantena così com'era nel file e non ricostruita dai campi
+type rawRecorder struct {
+	r    io.Reader
+	buf  []byte
+	base int64 // offset del primo byte di buf rispetto all'inizio della lettura
+}
+
+func (rr *rawRecorder) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.buf = append(rr.buf, p[:n]...)
+	return n, err
+}
+
+// discard scarta i byte che precedono 'offset', già consumati dal csv.Reader
+func (rr *rawRecorder) discard(offset int64) {
+	if rr == nil || offset <= rr.base {
+		return
+	}
+	rr.buf = rr.buf[offset-rr.base:]
+	rr.base = offset
+}
+
+// line restituisce il contenuto originale del record tra gli offset 'start' ed 'end', senza il fine riga
+func (rr *rawRecorder) line(start, end int64) string {
+	raw := rr.buf[start-rr.base : end-rr.base]
+	return strings.TrimRight(string(raw), "\r\n")
+}
+
+// rowSource descrive da dove arrivano i record letti, per localizzare le righe scartate
+type rowSource struct {
+	filename   string
+	baseOffset int64 // offset nel file del primo byte letto dal csv.Reader
+	chunked    bool  // nei chunk del parsing parallelo il numero di riga assoluto non è noto
+}
+
+// readUsers reads the data records (the header has already been consumed) and calls 'emit' for every valid user.
+// Malformed rows are rejected to cfg.Quarantine when it is set, otherwise the first one stops the reading.
+func readUsers(reader *csv.Reader, raw *rawRecorder, index columnIndex, cfg CSVConfig, src rowSource, emit func(models.User)) error {
+	// reject scrive la riga nella quarantena, oppure restituisce l'errore se la modalità è quella rigida
+	reject := func(line int, start int64, reason error) error {
+		if src.chunked {
+			line = 0
+		}
+		var content string
+		if raw != nil {
+			content = raw.line(start, reader.InputOffset())
+		}
+		return rejectRow(cfg, src, line, start, content, reason)
+	}
+
+	for {
+		start := reader.InputOffset()
+		raw.discard(start)
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			return nil, err
+			// Gli errori di parsing (es. una virgoletta dentro un campo) riguardano una sola riga: si può proseguire
+			var parseErr *csv.ParseError
+			if cfg.Quarantine == nil || !errors.As(err, &parseErr) {
+				return fmt.Errorf(constants.RecordsReadErrMessage, err)
+			}
+			// ...tranne quelli che si estendono su più righe: una virgoletta non chiusa ha inglobato
+			// le righe successive, fino alla fine del file, e non si sa da dove riprendere
+			if parseErr.Line > parseErr.StartLine {
+				return fmt.Errorf(constants.RecordsReadErrMessage, err)
+			}
+			if err := reject(parseErr.StartLine, start, parseErr.Err); err != nil {
+				return err
+			}
+			continue
 		}
-		users = append(users, user)
+
+		user, err := index.createUser(record)
+		if err == nil {
+			err = cfg.validate(user)
+		}
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			if err := reject(line, start, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if !src.chunked {
+			user.SourceLine, _ = reader.FieldPos(0)
+		}
+		emit(user)
 	}
+}
 
-	return users, nil
+// validate applica le regole di validazione configurate, se presenti
+func (cfg CSVConfig) validate(user models.User) error {
+	if cfg.Validator == nil {
+		return nil
+	}
+	return cfg.Validator.Validate(user)
+}
+
+// rejectRow scrive la riga nella quarantena di cfg, oppure, in modalità rigida, restituisce l'errore che interrompe la lettura.
+// 'line' è il numero di riga (0 se non noto) e 'start' l'offset del record rispetto a src.baseOffset.
+func rejectRow(cfg CSVConfig, src rowSource, line int, start int64, raw string, reason error) error {
+	if cfg.Quarantine == nil {
+		if line == 0 {
+			return fmt.Errorf("record all'offset %d: %w", src.baseOffset+start, reason)
+		}
+		return fmt.Errorf("riga %d: %w", line, reason)
+	}
+	return cfg.Quarantine.Reject(RejectedRow{
+		File:   src.filename,
+		Line:   line,
+		Raw:    raw,
+		Reason: reason.Error(),
+		Offset: src.baseOffset + start,
+	})
+}
+
+// DrainUsers consumes and discards every remaining user of the channel.
+// It is meant to be deferred by stream consumers that may return early,
+// so that the producer side (StreamCSV, Tee) is never left blocked.
+func DrainUsers(users <-chan models.User) {
+	for range users {
+	}
 }
 
 // safelyClose closes the file. If an error occurs during file closing, it logs the error.
 func safelyClose(file *os.File) {
 	err := file.Close()
 	if err != nil {
-		logger.ErrorAsync("Attenzione - Errore durante la chiusura del File!!: %v", err)
+		logger.ErrorAsync("Attenzione - Errore durante la chiusura del File!!: ", err)
 	}
 }
 
-// createUserFromRecord creates a models.User object from the given CSV record.
-// It parses the integer value from the first element of the record and assigns it to the ID field of the User object.
-// It assigns the second and third elements of the record to the NomeUtente and Email fields of the User object respectively.
-// If an error occurs during the conversion of the ID or if the record does not have enough elements, it returns an error.
-func createUserFromRecord(record []string) (models.User, error) {
-	id, err := strconv.Atoi(record[0])
-	if err != nil {
-		return models.User{}, fmt.Errorf("errore durante la conversione dell'id: %v", err)
-	}
-
-	user := models.User{
-		ID:         id,
-		NomeUtente: record[1],
-		Email:      record[2],
+// safelyCloseReader closes the input reader. If an error occurs during closing, it logs the error.
+func safelyCloseReader(r io.Closer) {
+	if err := r.Close(); err != nil {
+		logger.ErrorAsync("Attenzione - Errore durante la chiusura del File!!: ", err)
 	}
+}
 
-	return user, nil
+// createUserFromRecord creates a models.User object from the given CSV record using the historical positional layout:
+// the ID in the first column, NomeUtente in the second one and Email in the third one.
+// If an error occurs during the conversion of the ID or if the record does not have enough elements, it returns an error.
+// Files with a header are read through the header-driven columnIndex instead (see StreamCSVWithConfig).
+func createUserFromRecord(record []string) (models.User, error) {
+	return positionalColumnIndex.createUser(record)
 }
 
 // DisplayUsersAsJSON prints the users in JSON format.
 func DisplayUsersAsJSON(users []models.User) {
 	jsonData, err := json.MarshalIndent(users, "", "  ")
 	if err != nil {
-		logger.ErrorAsync("Errore durante la conversione in JSON: %v", err)
+		logger.ErrorAsync("Errore durante la conversione in JSON: ", err)
 	}
 	fmt.Println(string(jsonData))
 }
@@ -89,7 +349,7 @@ func WriteUsersToJSONFile(users []models.User, filename string) {
 	// Utilizza ffjson per la serializzazione
 	jsonData, err := ffjson.Marshal(users)
 	if err != nil {
-		logger.ErrorAsync("Errore durante la conversione in JSON: %v", err)
+		logger.ErrorAsync("Errore durante la conversione in JSON: ", err)
 		return
 	}
 
@@ -97,28 +357,82 @@ func WriteUsersToJSONFile(users []models.User, filename string) {
 	var indentedData bytes.Buffer
 	err = json.Indent(&indentedData, jsonData, "", "  ")
 	if err != nil {
-		logger.ErrorAsync("Errore durante l'indentazione del JSON: %v", err)
+		logger.ErrorAsync("Errore durante l'indentazione del JSON: ", err)
 		return
 	}
 
 	file, err := os.Create(filename)
 	if err != nil {
-		logger.ErrorAsync("Errore durante la creazione del file: %v", err)
+		logger.ErrorAsync("Errore durante la creazione del file: ", err)
 		return
 	}
 	defer safelyClose(file)
 
 	_, err = file.Write(indentedData.Bytes())
 	if err != nil {
-		logger.ErrorAsync("Errore durante la scrittura nel file: %v", err)
+		logger.ErrorAsync("Errore durante la scrittura nel file: ", err)
 		return
 	}
 
-	logger.InfoAsync("Dati scritti nel file %s con successo.\n", filename)
+	logger.InfoAsync("Dati scritti nel file ", filename, " con successo.")
 }
-func ConvertUsersToAvro(users []models.User) ([]byte, error) {
-	// Definizione dello schema Avro per gli utenti
-	schema := `
+
+// WriteUsersStreamToJSONFile scrive in un file JSON gli utenti ricevuti dal canale, man mano che arrivano.
+// Il risultato ha lo stesso formato di WriteUsersToJSONFile (un array indentato), ma in memoria
+// c'è un solo utente alla volta. In caso di errore il canale viene comunque svuotato.
+func WriteUsersStreamToJSONFile(users <-chan models.User, filename string) {
+	defer DrainUsers(users)
+
+	file, err := os.Create(filename)
+	if err != nil {
+		logger.ErrorAsync("Errore durante la creazione del file: ", err)
+		return
+	}
+	defer safelyClose(file)
+
+	writer := bufio.NewWriterSize(file, constants.WriteBufferSize)
+	var indentedData bytes.Buffer
+	separator := "[\n  "
+	for user := range users {
+		// Utilizza ffjson per la serializzazione del singolo utente
+		jsonData, err := ffjson.Marshal(&user)
+		if err != nil {
+			logger.ErrorAsync("Errore durante la conversione in JSON: ", err)
+			return
+		}
+
+		indentedData.Reset()
+		indentedData.WriteString(separator)
+		if err := json.Indent(&indentedData, jsonData, "  ", "  "); err != nil {
+			logger.ErrorAsync("Errore durante l'indentazione del JSON: ", err)
+			return
+		}
+
+		if _, err := writer.Write(indentedData.Bytes()); err != nil {
+			logger.ErrorAsync("Errore durante la scrittura nel file: ", err)
+			return
+		}
+		separator = ",\n  "
+	}
+
+	closing := "\n]"
+	if separator == "[\n  " {
+		closing = "[]" // nessun utente ricevuto
+	}
+	if _, err := writer.WriteString(closing); err != nil {
+		logger.ErrorAsync("Errore durante la scrittura nel file: ", err)
+		return
+	}
+	if err := writer.Flush(); err != nil {
+		logger.ErrorAsync("Errore durante la scrittura nel file: ", err)
+		return
+	}
+
+	logger.InfoAsync("Dati scritti nel file ", filename, " con successo.")
+}
+
+// UserAvroSchema è lo schema Avro di default per gli utenti, usato quando non viene caricato un file .avsc
+const UserAvroSchema = `
 	{
 		"type": "record",
 		"name": "User",
@@ -129,34 +443,60 @@ func ConvertUsersToAvro(users []models.User) ([]byte, error) {
 		]
 	}`
 
-	codec, err := goavro.NewCodec(schema)
+// ConvertUsersToAvro concatena le codifiche binarie Avro degli utenti, senza schema né sync marker:
+// per un file leggibile dagli strumenti Avro usare WriteAvroToFile.
+func ConvertUsersToAvro(users []models.User) ([]byte, error) {
+	encoder, err := NewAvroUserEncoder(UserAvroSchema, nil)
 	if err != nil {
-		return nil, fmt.Errorf("errore nella creazione del codec Avro: %v", err)
+		return nil, err
 	}
 
 	var avroData []byte
 	for _, user := range users {
-		// Conversione del singolo utente in una mappa compatibile con Avro
-		avroRecord := map[string]interface{}{
-			"ID":         user.ID,
-			"NomeUtente": user.NomeUtente,
-			"Email":      user.Email,
-		}
-
-		// Codifica del record Avro
-		binary, err := codec.BinaryFromNative(nil, avroRecord)
+		// Codifica del record Avro, accodata ai precedenti
+		avroData, err = encoder.Encode(avroData, user)
 		if err != nil {
-			return nil, fmt.Errorf("errore nella codifica Avro: %v", err)
+			return nil, err
 		}
-
-		avroData = append(avroData, binary...)
 	}
 
 	return avroData, nil
 }
-func WriteAvroToFile(avroData []byte, filename string) error {
-	err := os.WriteFile(filename, avroData, 0644)
+
+// WriteAvroStreamToFile codifica in Avro gli utenti ricevuti dal canale e li scrive nel file man mano,
+// senza tenere tutto in memoria, come Avro Object Container File (vedi WriteAvroToFile). 'schema' è lo schema
+// Avro da usare (UserAvroSchema oppure uno caricato con LoadAvroSchema), 'mapping' l'associazione tra le colonne
+// e i campi di User (la stessa del CSV, vedi NewAvroUserEncoder), 'opts' la compressione e la dimensione
+// dei blocchi. In caso di errore il canale viene comunque svuotato.
+func WriteAvroStreamToFile(users <-chan models.User, filename, schema string, mapping ColumnMapping, opts AvroOCFOptions) error {
+	defer DrainUsers(users)
+
+	encoder, err := NewAvroUserEncoder(schema, mapping)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("errore durante la scrittura dei dati Avro su file: %v", err)
+	}
+	defer safelyClose(file)
+
+	writer := bufio.NewWriterSize(file, constants.WriteBufferSize)
+	ocf, err := newAvroOCFWriter(writer, encoder, opts)
 	if err != nil {
+		return err
+	}
+	for user := range users {
+		if err := ocf.write(user); err != nil {
+			return err
+		}
+	}
+	if err := ocf.flush(); err != nil {
+		return err
+	}
+
+	if err := writer.Flush(); err != nil {
 		return fmt.Errorf("errore durante la scrittura dei dati Avro su file: %v", err)
 	}
 	return nil
@@ -169,3 +509,47 @@ func BatchUsers(users []models.User, batchSize int) [][]models.User {
 	}
 	return append(batches, users)
 }
+
+// BatchUsersStream groups the users received from the channel into batches of 'batchSize' elements.
+// Every batch is sent as soon as it is full, the last one may be shorter. The returned channel is closed
+// once the input channel is closed and the remaining users have been sent.
+func BatchUsersStream(users <-chan models.User, batchSize int) <-chan []models.User {
+	batches := make(chan []models.User)
+
+	go func() {
+		defer close(batches)
+
+		batch := make([]models.User, 0, batchSize)
+		for user := range users {
+			batch = append(batch, user)
+			if len(batch) == batchSize {
+				batches <- batch
+				batch = make([]models.User, 0, batchSize)
+			}
+		}
+		if len(batch) > 0 {
+			batches <- batch
+		}
+	}()
+
+	return batches
+}
+
+// SkipUsers discards the first 'n' users received from the channel and forwards the others.
+// It is used to resume an interrupted run from its checkpoint.
+func SkipUsers(users <-chan models.User, n int64) <-chan models.User {
+	if n <= 0 {
+		return users
+	}
+
+	out := make(chan models.User, constants.StreamBufferSize)
+	go func() {
+		defer close(out)
+		for user := range users {
+			if user.RowOffset > n {
+				out <- user
+			}
+		}
+	}()
+	return out
+}
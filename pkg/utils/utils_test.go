@@ -1,9 +1,16 @@
 package utils
 
 import (
+	"compress/gzip"
 	"csvreader/internal/models"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestSafelyClose(t *testing.T) {
@@ -39,3 +46,560 @@ func TestCreateUserFromRecord(t *testing.T) {
 		t.Errorf("Atteso errore durante la conversione dell'id, ma non si è verificato")
 	}
 }
+
+func TestStreamCSV(t *testing.T) {
+	// Setup: file CSV temporaneo con intestazione e due utenti
+	tempFile, err := os.CreateTemp("", "users*.csv")
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del file temporaneo: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+	tempFile.WriteString("id|nome_utente|email\n1|user1|user1@example.com\n2|user2|user2@example.com\n")
+	tempFile.Close()
+
+	usersCh, errCh := StreamCSV(tempFile.Name())
+	var users []models.User
+	for user := range usersCh {
+		users = append(users, user)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Errore durante la lettura dello stream: %v", err)
+	}
+
+	expected := []models.User{
+		{ID: 1, NomeUtente: "user1", Email: "user1@example.com", SourceLine: 2},
+		{ID: 2, NomeUtente: "user2", Email: "user2@example.com", SourceLine: 3},
+	}
+	if len(users) != len(expected) {
+		t.Fatalf("Utenti attesi: %d, ottenuti: %d", len(expected), len(users))
+	}
+	for i := range expected {
+		if users[i] != expected[i] {
+			t.Errorf("Utente atteso: %v, ottenuto: %v", expected[i], users[i])
+		}
+	}
+
+	// File inesistente: nessun utente e un errore
+	usersCh, errCh = StreamCSV("file_inesistente.csv")
+	DrainUsers(usersCh)
+	if err := <-errCh; err == nil {
+		t.Errorf("Atteso errore durante l'apertura del file, ma non si è verificato")
+	}
+}
+
+func TestBatchUsersStream(t *testing.T) {
+	usersCh := make(chan models.User)
+	go func() {
+		defer close(usersCh)
+		for i := 1; i <= 5; i++ {
+			usersCh <- models.User{ID: i}
+		}
+	}()
+
+	var sizes []int
+	for batch := range BatchUsersStream(usersCh, 2) {
+		sizes = append(sizes, len(batch))
+	}
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Errorf("Dimensioni dei batch attese: [2 2 1], ottenute: %v", sizes)
+	}
+}
+
+func TestStreamCSVTolerant(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := dir + "/users.csv"
+	content := "id|nome_utente|email\n1|user1|user1@example.com\nabc|user2|user2@example.com\n3|user3\n4|user4|user4@example.com\n"
+	if err := os.WriteFile(csvFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Errore durante la creazione del file temporaneo: %v", err)
+	}
+
+	quarantine, err := NewQuarantine(dir+"/rejected.jsonl", 0)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione della quarantena: %v", err)
+	}
+	cfg := DefaultCSVConfig()
+	cfg.Quarantine = quarantine
+
+	usersCh, errCh := StreamCSVWithConfig(csvFile, cfg)
+	var ids []int
+	for user := range usersCh {
+		ids = append(ids, user.ID)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Errore inatteso in modalità tollerante: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 4 {
+		t.Errorf("ID attesi: [1 4], ottenuti: %v", ids)
+	}
+	if err := quarantine.Close(); err != nil {
+		t.Fatalf("Errore durante la chiusura della quarantena: %v", err)
+	}
+	if quarantine.Rejected() != 2 {
+		t.Errorf("Righe scartate attese: 2, ottenute: %d", quarantine.Rejected())
+	}
+	rejected, _ := os.ReadFile(dir + "/rejected.jsonl")
+	if !strings.Contains(string(rejected), `"line":3,"raw":"abc|user2|user2@example.com"`) {
+		t.Errorf("Riga scartata non trovata nel file di quarantena: %s", rejected)
+	}
+
+	// La riga scartata per un errore di parsing finisce in quarantena con il contenuto originale, virgolette comprese
+	malformed := dir + "/malformed.csv"
+	content = "id|nome_utente|email\n1|user1|user1@example.com\n2|\"us\"er2|user2@example.com\r\n3|user3|user3@example.com\n"
+	if err := os.WriteFile(malformed, []byte(content), 0644); err != nil {
+		t.Fatalf("Errore durante la creazione del file temporaneo: %v", err)
+	}
+	quarantine, _ = NewQuarantine(dir+"/rejected_parse.jsonl", 0)
+	cfg.Quarantine = quarantine
+	usersCh, errCh = StreamCSVWithConfig(malformed, cfg)
+	ids = ids[:0]
+	for user := range usersCh {
+		ids = append(ids, user.ID)
+	}
+	if err := <-errCh; err != nil || len(ids) != 2 || ids[1] != 3 {
+		t.Errorf("Attesi gli ID [1 3] senza errori, ottenuti: %v, %v", ids, err)
+	}
+	quarantine.Close()
+	rejected, _ = os.ReadFile(dir + "/rejected_parse.jsonl")
+	if !strings.Contains(string(rejected), `"line":3,"raw":"2|\"us\"er2|user2@example.com"`) {
+		t.Errorf("Riga originale non trovata nel file di quarantena: %s", rejected)
+	}
+
+	// Una virgoletta non chiusa inghiotte tutte le righe successive: la lettura si interrompe invece di perderle
+	if err := os.WriteFile(malformed, []byte("id|nome_utente|email\n1|\"bad|x\n2|y|z\n"), 0644); err != nil {
+		t.Fatalf("Errore durante la creazione del file temporaneo: %v", err)
+	}
+	quarantine, _ = NewQuarantine(dir+"/rejected_quote.jsonl", 0)
+	cfg.Quarantine = quarantine
+	usersCh, errCh = StreamCSVWithConfig(malformed, cfg)
+	DrainUsers(usersCh)
+	if err := <-errCh; err == nil {
+		t.Error("Atteso errore per una virgoletta non chiusa, ma non si è verificato")
+	}
+	quarantine.Close()
+
+	// Con una soglia di 1 la seconda riga scartata interrompe la lettura
+	quarantine, _ = NewQuarantine(dir+"/rejected_max.jsonl", 1)
+	defer quarantine.Close()
+	cfg.Quarantine = quarantine
+	usersCh, errCh = StreamCSVWithConfig(csvFile, cfg)
+	DrainUsers(usersCh)
+	if err := <-errCh; !errors.Is(err, ErrTooManyRejected) {
+		t.Errorf("Atteso ErrTooManyRejected, ottenuto: %v", err)
+	}
+}
+
+func TestStreamCSVCompressed(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("id|nome_utente|email\n1|user1|user1@example.com\n2|user2|user2@example.com\n")
+
+	// gzip, riconosciuto dall'estensione e dai magic bytes
+	gzFile, err := os.Create(filepath.Join(dir, "users.csv.gz"))
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del file temporaneo: %v", err)
+	}
+	gz := gzip.NewWriter(gzFile)
+	gz.Write(content)
+	gz.Close()
+	gzFile.Close()
+
+	// zstd senza estensione: riconosciuto solo dai magic bytes
+	zstdFile, err := os.Create(filepath.Join(dir, "users_zstd"))
+	if err != nil {
+		t.Fatalf("Errore durante la creazione del file temporaneo: %v", err)
+	}
+	zw, _ := zstd.NewWriter(zstdFile)
+	zw.Write(content)
+	zw.Close()
+	zstdFile.Close()
+
+	for _, name := range []string{"users.csv.gz", "users_zstd"} {
+		usersCh, errCh := StreamCSV(filepath.Join(dir, name))
+		count := 0
+		for range usersCh {
+			count++
+		}
+		if err := <-errCh; err != nil {
+			t.Errorf("%s: errore durante la lettura dello stream: %v", name, err)
+		}
+		if count != 2 {
+			t.Errorf("%s: utenti attesi: 2, ottenuti: %d", name, count)
+		}
+	}
+
+	if got := detectCompression("users.csv.bz2", []byte("id|n")); got != CompressionBzip2 {
+		t.Errorf("Compressione attesa: %s, ottenuta: %s", CompressionBzip2, got)
+	}
+	if got := detectCompression("users.csv", []byte("id|n")); got != CompressionNone {
+		t.Errorf("Compressione attesa: %s, ottenuta: %s", CompressionNone, got)
+	}
+	// Un CSV in chiaro che inizia con "BZh" non è bzip2 senza la cifra della dimensione dei blocchi
+	if got := detectCompression("users.csv", []byte("BZh|")); got != CompressionNone {
+		t.Errorf("Compressione attesa: %s, ottenuta: %s", CompressionNone, got)
+	}
+	if got := detectCompression("users", []byte("BZh9")); got != CompressionBzip2 {
+		t.Errorf("Compressione attesa: %s, ottenuta: %s", CompressionBzip2, got)
+	}
+	// L'estensione ha la precedenza sui magic bytes
+	if got := detectCompression("users.csv.gz", zstdMagic); got != CompressionGzip {
+		t.Errorf("Compressione attesa: %s, ottenuta: %s", CompressionGzip, got)
+	}
+}
+
+func TestStreamCSVParallel(t *testing.T) {
+	// Setup: file con abbastanza righe da essere diviso in molti chunk piccoli
+	var content strings.Builder
+	content.WriteString("id|nome_utente|email\n")
+	for i := 1; i <= 1000; i++ {
+		content.WriteString(fmt.Sprintf("%d|user%d|user%d@example.com\n", i, i, i))
+	}
+	csvFile := filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(csvFile, []byte(content.String()), 0644); err != nil {
+		t.Fatalf("Errore durante la creazione del file temporaneo: %v", err)
+	}
+
+	cfg := DefaultCSVConfig()
+	cfg.Workers, cfg.ChunkSize = 4, 100
+
+	// Modalità ordinata: stesso ordine del file
+	cfg.Ordered = true
+	usersCh, errCh := StreamCSVParallel(csvFile, cfg)
+	expectedID := 1
+	for user := range usersCh {
+		if user.ID != expectedID {
+			t.Fatalf("ID atteso: %d, ottenuto: %d", expectedID, user.ID)
+		}
+		expectedID++
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Errore durante il parsing parallelo: %v", err)
+	}
+	if expectedID != 1001 {
+		t.Errorf("Utenti attesi: 1000, ottenuti: %d", expectedID-1)
+	}
+
+	// Modalità non ordinata: stessi utenti, ordine qualsiasi
+	cfg.Ordered = false
+	usersCh, errCh = StreamCSVParallel(csvFile, cfg)
+	seen := make(map[int]bool)
+	for user := range usersCh {
+		seen[user.ID] = true
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Errore durante il parsing parallelo: %v", err)
+	}
+	if len(seen) != 1000 {
+		t.Errorf("Utenti distinti attesi: 1000, ottenuti: %d", len(seen))
+	}
+}
+
+func TestStreamFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"users_1.csv": "id|nome_utente|email\n1|user1|user1@example.com\n2|user2|user2@example.com\n",
+		"users_2.csv": "id|nome_utente|email\n3|user3|user3@example.com\n",
+		"users_3.csv": "id|nome_utente\n4|user4\n", // colonna email mancante
+		"notes.txt":   "non è un CSV",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Errore durante la creazione del file temporaneo: %v", err)
+		}
+	}
+
+	// Directory: solo i file CSV, in ordine di nome
+	inputs, err := ResolveInputs(dir, FormatCSV)
+	if err != nil {
+		t.Fatalf("Errore durante la risoluzione della directory: %v", err)
+	}
+	if len(inputs) != 3 || filepath.Base(inputs[0]) != "users_1.csv" {
+		t.Errorf("File attesi: users_1..3.csv, ottenuti: %v", inputs)
+	}
+
+	// Glob
+	inputs, err = ResolveInputs(filepath.Join(dir, "users_[12].csv"), FormatCSV)
+	if err != nil || len(inputs) != 2 {
+		t.Errorf("File attesi: 2, ottenuti: %v (errore: %v)", inputs, err)
+	}
+	if _, err := ResolveInputs(filepath.Join(dir, "missing_*.csv"), FormatCSV); err == nil {
+		t.Errorf("Atteso errore per un pattern senza corrispondenze, ma non si è verificato")
+	}
+
+	// Un file con errori non interrompe la lettura degli altri
+	inputs, _ = ResolveInputs(dir, FormatCSV)
+	usersCh, errCh, report := StreamFiles(inputs, DefaultCSVConfig())
+	sources := make(map[string]int)
+	for user := range usersCh {
+		sources[filepath.Base(user.SourceFile)]++
+	}
+	if err := <-errCh; err == nil || !strings.Contains(err.Error(), "users_3.csv") {
+		t.Errorf("Atteso errore per users_3.csv, ottenuto: %v", err)
+	}
+	if sources["users_1.csv"] != 2 || sources["users_2.csv"] != 1 {
+		t.Errorf("Utenti per file attesi: users_1.csv=2 users_2.csv=1, ottenuti: %v", sources)
+	}
+
+	stats := report.Files()
+	if len(stats) != 3 || stats[0].Users != 2 || stats[1].Users != 1 || stats[2].Err == nil {
+		t.Errorf("Statistiche per file inattese: %+v", stats)
+	}
+}
+
+func TestUserReaderFormats(t *testing.T) {
+	dir := t.TempDir()
+	expected := []models.User{
+		{ID: 1, NomeUtente: "user1", Email: "user1@example.com"},
+		{ID: 2, NomeUtente: "user2", Email: "user2@example.com"},
+	}
+
+	fixedColumns, err := ParseFixedWidthColumns("ID:0:4,NomeUtente:4:8,Email:12:30")
+	if err != nil {
+		t.Fatalf("Errore durante il parsing del layout a larghezza fissa: %v", err)
+	}
+
+	tests := []struct {
+		format  InputFormat
+		content string
+		lines   [2]int // righe dei due utenti nel file
+	}{
+		{FormatTSV, "email\tid\tnome_utente\nuser1@example.com\t1\tuser1\nuser2@example.com\t2\tuser2\n", [2]int{2, 3}},
+		{FormatJSONLines, `{"id": 1, "nome_utente": "user1", "email": "user1@example.com"}` + "\n\n" +
+			`{"email": "user2@example.com", "id": "2", "nome_utente": "user2", "extra": true}` + "\n", [2]int{1, 3}},
+		{FormatFixedWidth, "1   user1   user1@example.com\n2   user2   user2@example.com     \n", [2]int{1, 2}},
+	}
+
+	for _, tt := range tests {
+		filename := filepath.Join(dir, "users."+string(tt.format))
+		if err := os.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+			t.Fatalf("Errore durante la creazione del file temporaneo: %v", err)
+		}
+
+		cfg := DefaultCSVConfig()
+		cfg.Format, cfg.FixedWidth = tt.format, fixedColumns
+		usersCh, errCh := StreamCSVWithConfig(filename, cfg)
+		var users []models.User
+		for user := range usersCh {
+			users = append(users, user)
+		}
+		if err := <-errCh; err != nil {
+			t.Errorf("%s: errore durante la lettura: %v", tt.format, err)
+			continue
+		}
+		expected[0].SourceLine, expected[1].SourceLine = tt.lines[0], tt.lines[1]
+		if len(users) != len(expected) || users[0] != expected[0] || users[1] != expected[1] {
+			t.Errorf("%s: utenti attesi: %v, ottenuti: %v", tt.format, expected, users)
+		}
+	}
+
+	// Oggetto JSON senza email: errore in modalità rigida
+	filename := filepath.Join(dir, "invalid.jsonl")
+	os.WriteFile(filename, []byte(`{"id": 1, "nome_utente": "user1"}`+"\n"), 0644)
+	cfg := DefaultCSVConfig()
+	cfg.Format = FormatJSONLines
+	usersCh, errCh := StreamCSVWithConfig(filename, cfg)
+	DrainUsers(usersCh)
+	if err := <-errCh; err == nil {
+		t.Errorf("Atteso errore per il campo email mancante, ma non si è verificato")
+	}
+
+	if _, err := ParseFixedWidthColumns("ID:0:4,Email:4:20"); err == nil {
+		t.Errorf("Atteso errore per la colonna NomeUtente mancante, ma non si è verificato")
+	}
+}
+
+func TestInferAvroSchema(t *testing.T) {
+	content := "id|nome_utente|email|score|active|big|note\n" +
+		"1|user1|user1@example.com|1.5|true|3000000000|\n" +
+		"2|user2|user2@example.com|2|false|1|x\n"
+
+	schema, err := InferAvroSchema(strings.NewReader(content), DefaultCSVConfig(), 100, "User", "csvreader")
+	if err != nil {
+		t.Fatalf("Errore durante l'inferenza dello schema: %v", err)
+	}
+	for _, expected := range []string{
+		`"name": "id",
+      "type": "int"`,
+		`"name": "score",
+      "type": "double"`,
+		`"name": "active",
+      "type": "boolean"`,
+		`"name": "big",
+      "type": "long"`,
+		`"name": "note",
+      "type": [
+        "null",
+        "string"
+      ],
+      "default": null`,
+	} {
+		if !strings.Contains(schema, expected) {
+			t.Errorf("Campo atteso nello schema: %s\nschema: %s", expected, schema)
+		}
+	}
+
+	// Lo schema inferito contiene campi che User non ha: senza default non può essere usato
+	if _, err := NewAvroUserEncoder(schema, nil); err == nil {
+		t.Errorf("Atteso errore per i campi dello schema non associati a User, ma non si è verificato")
+	}
+
+	// Schema con i soli campi di User, con nomi e tipi diversi da quelli di default
+	schema, err = InferAvroSchema(strings.NewReader("ID|nome_utente|email\n1|user1|\n"), DefaultCSVConfig(), 0, "User", "")
+	if err != nil {
+		t.Fatalf("Errore durante l'inferenza dello schema: %v", err)
+	}
+	encoder, err := NewAvroUserEncoder(schema, nil)
+	if err != nil {
+		t.Fatalf("Errore durante la creazione dell'encoder: %v", err)
+	}
+	user := models.User{ID: 1, NomeUtente: "user1", Email: "user1@example.com"}
+	binary, err := encoder.Encode(nil, user)
+	if err != nil {
+		t.Fatalf("Errore durante la codifica Avro: %v", err)
+	}
+	native, _, err := encoder.Codec().NativeFromBinary(binary)
+	if err != nil {
+		t.Fatalf("Errore durante la decodifica Avro: %v", err)
+	}
+	record := native.(map[string]interface{})
+	if record["ID"] != int32(1) || record["email"].(map[string]interface{})["string"] != "user1@example.com" {
+		t.Errorf("Record decodificato inatteso: %v", record)
+	}
+}
+
+func TestInferAvroSchemaRenamedHeaders(t *testing.T) {
+	// Le colonne rinominate diventano campi con il nome reso valido per Avro: user_id, user_name ed e_mail
+	content := "user_id|user name|e-mail\n1|user1|user1@example.com\n2|user2|user2@example.com\n"
+	schema, err := InferAvroSchema(strings.NewReader(content), DefaultCSVConfig(), 0, "User", "")
+	if err != nil {
+		t.Fatalf("Errore durante l'inferenza dello schema: %v", err)
+	}
+	if _, err := NewAvroUserEncoder(schema, nil); err == nil {
+		t.Errorf("Atteso errore senza la mappatura delle colonne, ma non si è verificato")
+	}
+
+	mapping, err := ParseColumnMapping("user_id=ID,user name=NomeUtente,e-mail=Email")
+	if err != nil {
+		t.Fatalf("Errore durante il parsing della mappatura: %v", err)
+	}
+	users := []models.User{
+		{ID: 1, NomeUtente: "user1", Email: "user1@example.com"},
+		{ID: 2, NomeUtente: "user2", Email: "user2@example.com"},
+	}
+	filename := filepath.Join(t.TempDir(), "users.avro")
+	if err := WriteAvroToFile(users, filename, schema, mapping, DefaultAvroOCFOptions()); err != nil {
+		t.Fatalf("Errore durante la scrittura del file Avro: %v", err)
+	}
+	read, err := ReadAvroFile(filename, mapping)
+	if err != nil {
+		t.Fatalf("Errore durante la rilettura del file Avro: %v", err)
+	}
+	if fmt.Sprint(read) != fmt.Sprint(users) {
+		t.Errorf("Utenti riletti diversi da quelli scritti: %v", read)
+	}
+}
+
+func TestAvroOCF(t *testing.T) {
+	var users []models.User
+	for i := 1; i <= 5; i++ {
+		users = append(users, models.User{ID: i, NomeUtente: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+
+	// Blocchi da 2 record: l'ultimo blocco contiene un solo record
+	for _, compression := range []string{"null", "deflate", "snappy"} {
+		filename := filepath.Join(t.TempDir(), "users.avro")
+		if err := WriteAvroToFile(users, filename, UserAvroSchema, nil, AvroOCFOptions{Compression: compression, BlockSize: 2}); err != nil {
+			t.Fatalf("Errore durante la scrittura del file Avro %s: %v", compression, err)
+		}
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Errore durante la lettura del file Avro: %v", err)
+		}
+		if !strings.HasPrefix(string(data), "Obj\x01") || !strings.Contains(string(data), `"NomeUtente"`) {
+			t.Errorf("Atteso un Object Container File con lo schema nell'header (%s)", compression)
+		}
+
+		read, err := ReadAvroFile(filename, nil)
+		if err != nil {
+			t.Fatalf("Errore durante la rilettura del file Avro %s: %v", compression, err)
+		}
+		if fmt.Sprint(read) != fmt.Sprint(users) {
+			t.Errorf("Utenti riletti diversi da quelli scritti (%s): %v", compression, read)
+		}
+	}
+
+	err := WriteAvroToFile(users, filepath.Join(t.TempDir(), "users.avro"), UserAvroSchema, nil, AvroOCFOptions{Compression: "gzip", BlockSize: 2})
+	if err == nil {
+		t.Error("Atteso errore per una compressione non supportata, ma non si è verificato")
+	}
+}
+
+func TestProtobufFile(t *testing.T) {
+	users := []models.User{
+		{ID: 1, NomeUtente: "user1", Email: "user1@example.com"},
+		{ID: 2, NomeUtente: "", Email: "user2@example.com"}, // i campi vuoti non vengono serializzati
+		{ID: 300000, NomeUtente: "user3", Email: "user3@example.com"},
+		{ID: 1 << 40, NomeUtente: "user4", Email: "user4@example.com"}, // oltre int32: nessun troncamento
+	}
+	filename := filepath.Join(t.TempDir(), "users.pb")
+	if err := WriteProtobufToFile(users, filename); err != nil {
+		t.Fatalf("Errore durante la scrittura del file Protobuf: %v", err)
+	}
+
+	read, err := ReadProtobufFile(filename)
+	if err != nil {
+		t.Fatalf("Errore durante la rilettura del file Protobuf: %v", err)
+	}
+	if fmt.Sprint(read) != fmt.Sprint(users) {
+		t.Errorf("Utenti riletti diversi da quelli scritti: %v", read)
+	}
+
+	// Un file troncato a metà di un messaggio non viene letto in silenzio
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Errore durante la lettura del file Protobuf: %v", err)
+	}
+	if err := os.WriteFile(filename, data[:len(data)-3], 0644); err != nil {
+		t.Fatalf("Errore durante la scrittura del file troncato: %v", err)
+	}
+	if _, err := ReadProtobufFile(filename); err == nil {
+		t.Error("Atteso errore per un file troncato, ma non si è verificato")
+	}
+}
+
+func TestValidator(t *testing.T) {
+	rules, err := ParseValidationRules("ID:positive,unique;NomeUtente:required,max=5;Email:required,email")
+	if err != nil {
+		t.Fatalf("Errore durante il parsing delle regole: %v", err)
+	}
+	validator := NewValidator(rules)
+
+	tests := []struct {
+		user  models.User
+		valid bool
+	}{
+		{models.User{ID: 1, NomeUtente: "user1", Email: "user1@example.com"}, true},
+		{models.User{ID: 1, NomeUtente: "user2", Email: "user2@example.com"}, false}, // id duplicato
+		{models.User{ID: -2, NomeUtente: "user2", Email: "user2@example.com"}, false},
+		{models.User{ID: 3, NomeUtente: " ", Email: "user3@example.com"}, false},
+		{models.User{ID: 4, NomeUtente: "user_4", Email: "user4@example.com"}, false}, // troppo lungo
+		{models.User{ID: 5, NomeUtente: "user5", Email: "user5.example.com"}, false},
+		{models.User{ID: 6, NomeUtente: "user6", Email: "User 6 <user6@example.com>"}, false},
+	}
+	for _, tt := range tests {
+		if err := validator.Validate(tt.user); (err == nil) != tt.valid {
+			t.Errorf("Utente %v: validità attesa %v, errore: %v", tt.user, tt.valid, err)
+		}
+	}
+
+	expected := "Email.email=2, ID.positive=1, ID.unique=1, NomeUtente.max=1, NomeUtente.required=1"
+	if summary := validator.Summary(); summary != expected {
+		t.Errorf("Riepilogo atteso: %s, ottenuto: %s", expected, summary)
+	}
+
+	// Le regole che non si applicano al tipo del campo vengono rifiutate invece di essere ignorate
+	for _, invalid := range []string{"Email:unique", "ID:required", "ID:max=5", "NomeUtente:positive"} {
+		if _, err := ParseValidationRules(invalid); err == nil {
+			t.Errorf("Atteso errore per le regole %q, ma non si è verificato", invalid)
+		}
+	}
+}
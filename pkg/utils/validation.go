@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"csvreader/internal/models"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Nomi delle regole di validazione, usati nella configurazione e nel riepilogo delle violazioni
+const (
+	RuleRequired = "required" // il campo di testo non può essere vuoto
+	RuleEmail    = "email"    // il campo deve essere un indirizzo email sintatticamente valido
+	RulePositive = "positive" // l'id deve essere maggiore di zero
+	RuleUnique   = "unique"   // l'id non può ripetersi nella stessa run
+	RuleMax      = "max"      // lunghezza massima in caratteri del campo di testo, scritta come max=N
+)
+
+// FieldRules sono le regole applicate a un singolo campo di models.User
+type FieldRules struct {
+	Required  bool
+	Email     bool
+	Positive  bool
+	Unique    bool
+	MaxLength int // 0 = nessun limite
+}
+
+// ValidationRules associa a ogni campo di models.User (FieldID, FieldNomeUtente, FieldEmail) le sue regole
+type ValidationRules map[string]FieldRules
+
+// DefaultValidationRules returns the rules used by "-validate default": positive and unique ID,
+// non-empty username of at most 100 characters, valid email of at most 254 characters.
+func DefaultValidationRules() ValidationRules {
+	return ValidationRules{
+		FieldID:         {Positive: true, Unique: true},
+		FieldNomeUtente: {Required: true, MaxLength: 100},
+		FieldEmail:      {Required: true, Email: true, MaxLength: 254},
+	}
+}
+
+// ParseValidationRules parses rules written as "Field:rule,rule;Field:rule", for example
+// "ID:positive,unique;NomeUtente:required,max=50;Email:required,email". The word "default"
+// selects DefaultValidationRules. Positive and unique only apply to the integer ID, required, email and max
+// only to the text fields: a rule that does not apply to the type of its field is an error, not a no-op.
+func ParseValidationRules(s string) (ValidationRules, error) {
+	if strings.TrimSpace(s) == "default" {
+		return DefaultValidationRules(), nil
+	}
+
+	rules := make(ValidationRules)
+	for _, spec := range strings.Split(s, ";") {
+		field, list, ok := strings.Cut(strings.TrimSpace(spec), ":")
+		if !ok || !isUserField(field) {
+			return nil, fmt.Errorf("regole di validazione non valide %q: atteso formato Campo:regola,regola con Campo tra %s",
+				spec, strings.Join(requiredFields, ", "))
+		}
+
+		var fieldRules FieldRules
+		for _, rule := range strings.Split(list, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(rule), "=")
+			switch {
+			case name == RuleRequired && field != FieldID:
+				fieldRules.Required = true
+			case name == RuleEmail && field != FieldID:
+				fieldRules.Email = true
+			case name == RulePositive && field == FieldID:
+				fieldRules.Positive = true
+			case name == RuleUnique && field == FieldID:
+				fieldRules.Unique = true
+			case name == RuleMax && field != FieldID:
+				max, err := strconv.Atoi(value)
+				if err != nil || max <= 0 {
+					return nil, fmt.Errorf("regola %q del campo %s: la lunghezza massima deve essere un numero positivo", rule, field)
+				}
+				fieldRules.MaxLength = max
+			default:
+				return nil, fmt.Errorf("regola %q non applicabile al campo %s", rule, field)
+			}
+		}
+		rules[field] = fieldRules
+	}
+	return rules, nil
+}
+
+// Validator applica le ValidationRules agli utenti letti e conta le violazioni per il riepilogo della run.
+// È sicuro per l'uso concorrente, così può essere condiviso dai chunk del parsing parallelo e dai vari file:
+// l'unicità dell'id vale per l'intera run. Con il parsing parallelo non ordinato, quale dei duplicati
+// venga scartato dipende dall'ordine in cui vengono analizzati i chunk.
+type Validator struct {
+	rules      ValidationRules
+	mu         sync.Mutex
+	seenIDs    map[int]struct{}
+	violations map[string]int // chiave "Campo.regola"
+}
+
+// NewValidator creates a validator applying 'rules'.
+func NewValidator(rules ValidationRules) *Validator {
+	return &Validator{
+		rules:      rules,
+		seenIDs:    make(map[int]struct{}),
+		violations: make(map[string]int),
+	}
+}
+
+// Validate checks the user against every rule and returns an error describing all the violations, or nil.
+// A user rejected for any reason does not reserve its ID, so a later valid duplicate is still accepted.
+func (v *Validator) Validate(user models.User) error {
+	var failed []string
+	check := func(field, rule string, ok bool) {
+		if !ok {
+			failed = append(failed, field+"."+rule)
+		}
+	}
+
+	idRules := v.rules[FieldID]
+	if idRules.Positive {
+		check(FieldID, RulePositive, user.ID > 0)
+	}
+	for _, field := range []string{FieldNomeUtente, FieldEmail} {
+		rules := v.rules[field]
+		value := userFieldValue(user, field).(string)
+		if rules.Required {
+			check(field, RuleRequired, strings.TrimSpace(value) != "")
+		}
+		if rules.Email && value != "" {
+			check(field, RuleEmail, isValidEmail(value))
+		}
+		if rules.MaxLength > 0 {
+			check(field, RuleMax, utf8.RuneCountInString(value) <= rules.MaxLength)
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if idRules.Unique && len(failed) == 0 {
+		_, duplicate := v.seenIDs[user.ID]
+		check(FieldID, RuleUnique, !duplicate)
+		if !duplicate {
+			v.seenIDs[user.ID] = struct{}{}
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	for _, violation := range failed {
+		v.violations[violation]++
+	}
+	return fmt.Errorf("validazione fallita: %s", strings.Join(failed, ", "))
+}
+
+// Violations returns how many times each rule has been violated, keyed by "Field.rule".
+func (v *Validator) Violations() map[string]int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	violations := make(map[string]int, len(v.violations))
+	for rule, count := range v.violations {
+		violations[rule] = count
+	}
+	return violations
+}
+
+// Summary returns the violations as a single readable line, sorted by rule, for the run summary.
+func (v *Validator) Summary() string {
+	violations := v.Violations()
+	if len(violations) == 0 {
+		return "no violations"
+	}
+
+	rules := make([]string, 0, len(violations))
+	for rule := range violations {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+
+	parts := make([]string, len(rules))
+	for i, rule := range rules {
+		parts[i] = fmt.Sprintf("%s=%d", rule, violations[rule])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// isValidEmail accetta solo un indirizzo semplice, senza nome visualizzato né spazi
+func isValidEmail(value string) bool {
+	address, err := mail.ParseAddress(value)
+	return err == nil && address.Address == value && address.Name == ""
+}